@@ -17,8 +17,27 @@ type Mysql struct {
 	Password string `ysml:"password" json:"password"`
 }
 
+type Sqlite struct {
+	// 数据库文件的路径，默认为配置文件所在目录中的nextlist.db
+	Path string `yaml:"path" json:"path"`
+}
+
+type Postgres struct {
+	Url      string `yaml:"url" json:"url"`
+	Port     int    `yaml:"port" json:"port"`
+	Database string `yaml:"database" json:"database"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+	// 默认为disable
+	SslMode string `yaml:"sslMode" json:"sslMode"`
+}
+
 type DataBase struct {
-	Mysql Mysql `ysml:"mysql" json:"mysql"`
+	// 数据库类型，支持mysql、sqlite以及postgres，默认为mysql
+	Type     string   `yaml:"type" json:"type"`
+	Mysql    Mysql    `ysml:"mysql" json:"mysql"`
+	Sqlite   Sqlite   `yaml:"sqlite" json:"sqlite"`
+	Postgres Postgres `yaml:"postgres" json:"postgres"`
 }
 
 type Auth struct {
@@ -31,16 +50,39 @@ type SiteConfig struct {
 	CopyRight     string `yaml:"copyright" json:"copyright"`
 }
 
+type TrashConfig struct {
+	// 回收站中的文件保留的天数，超过后会被彻底删除，默认为30天
+	RetentionDays int `yaml:"retentionDays" json:"retentionDays"`
+}
+
+type UploadConfig struct {
+	// 断点续传时保存未上传完成的数据的目录，默认为系统的临时目录
+	TempDir string `yaml:"tempDir" json:"tempDir"`
+	// 分片上传超过这个天数还没有完成会被取消，默认为7天
+	MultipartExpireDays int `yaml:"multipartExpireDays" json:"multipartExpireDays"`
+	// 创建后超过这个天数还没有上传完成的文件会被删除，默认为7天
+	ReadyExpireDays int `yaml:"readyExpireDays" json:"readyExpireDays"`
+}
+
 type DriverConfig struct {
 	Name   string                 `yaml:"name" json:"name"`
 	Config map[string]interface{} `yaml:"config" json:"config"`
 }
 
+// MountConfig 将一个驱动挂载到虚拟路径下，例如 /nas
+type MountConfig struct {
+	Path         string `yaml:"path" json:"path"`
+	DriverConfig `yaml:",inline"`
+}
+
 type Config struct {
-	DataBase     DataBase     `yaml:"database" json:"database"`
-	Auth         Auth         `yaml:"auth" json:"auth"`
-	DriverConfig DriverConfig `yaml:"driver" json:"driver"`
-	SiteConfig   SiteConfig   `yaml:"site" json:"site"`
+	DataBase     DataBase      `yaml:"database" json:"database"`
+	Auth         Auth          `yaml:"auth" json:"auth"`
+	DriverConfig DriverConfig  `yaml:"driver" json:"driver"`
+	Mounts       []MountConfig `yaml:"mounts" json:"mounts"`
+	SiteConfig   SiteConfig    `yaml:"site" json:"site"`
+	TrashConfig  TrashConfig   `yaml:"trash" json:"trash"`
+	UploadConfig UploadConfig  `yaml:"upload" json:"upload"`
 }
 
 var GlobalConfig *Config
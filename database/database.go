@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/glebarez/sqlite"
+	"github.com/lixiaofei123/nextlist/configs"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const (
+	MYSQL    string = "mysql"
+	SQLITE   string = "sqlite"
+	POSTGRES string = "postgres"
+)
+
+// defaultSqlitePath 与配置文件保存在同一个目录中，容器中只需要挂载这一个目录
+const defaultSqlitePath string = "/app/config/nextlist.db"
+
+// Open 根据配置的数据库类型连接数据库，没有配置类型时使用MySQL
+func Open(config *configs.DataBase, gormConfig *gorm.Config) (*gorm.DB, error) {
+
+	dialector, err := newDialector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if gormConfig == nil {
+		gormConfig = &gorm.Config{}
+	}
+
+	return gorm.Open(dialector, gormConfig)
+}
+
+// Check 检查能否连接到数据库
+func Check(config *configs.DataBase) error {
+
+	db, err := Open(config, nil)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	return db.Exec("select 1").Error
+}
+
+func newDialector(config *configs.DataBase) (gorm.Dialector, error) {
+
+	switch config.Type {
+	case "", MYSQL:
+		return mysql.Open(fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
+			config.Mysql.Username,
+			config.Mysql.Password,
+			config.Mysql.Url,
+			config.Mysql.Port,
+			config.Mysql.Database,
+		)), nil
+	case SQLITE:
+		dbPath := config.Sqlite.Path
+		if dbPath == "" {
+			dbPath = defaultSqlitePath
+		}
+		if err := os.MkdirAll(path.Dir(dbPath), 0755); err != nil {
+			return nil, err
+		}
+		// 同步等后台任务和请求会同时写入，写入冲突时等待而不是直接返回错误
+		return sqlite.Open(dbPath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"), nil
+	case POSTGRES:
+		sslMode := config.Postgres.SslMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(config.Postgres.Username, config.Postgres.Password),
+			Host:     config.Postgres.Url + ":" + strconv.Itoa(config.Postgres.Port),
+			Path:     "/" + config.Postgres.Database,
+			RawQuery: url.Values{"sslmode": []string{sslMode}}.Encode(),
+		}
+		return postgres.Open(dsn.String()), nil
+	}
+
+	return nil, fileerr.ErrUnSupportDatabase
+}
@@ -0,0 +1,188 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"github.com/lixiaofei123/nextlist/models"
+	"gorm.io/gorm"
+)
+
+// Migration 是一次数据库结构或者数据的变更，Version从1开始递增并且发布后不能再修改。
+// Down为空的迁移不能回滚
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// MigrationStatus 是迁移的执行状态，AppliedAt为空表示还没有执行
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+// Migrate 按照版本号从小到大执行所有还没有执行的迁移，返回本次执行的迁移
+func Migrate(db *gorm.DB) ([]*Migration, error) {
+	return migrate(db, migrations)
+}
+
+// Rollback 从最新的版本开始回滚steps个已经执行的迁移，返回本次回滚的迁移
+func Rollback(db *gorm.DB, steps int) ([]*Migration, error) {
+	return rollback(db, migrations, steps)
+}
+
+// Status 返回所有迁移的执行状态
+func Status(db *gorm.DB) ([]*MigrationStatus, error) {
+	return status(db, migrations)
+}
+
+func appliedMigrations(db *gorm.DB) (map[int]*models.SchemaMigration, error) {
+
+	if err := db.AutoMigrate(&models.SchemaMigration{}); err != nil {
+		return nil, err
+	}
+
+	records := []*models.SchemaMigration{}
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := map[int]*models.SchemaMigration{}
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+
+	return applied, nil
+}
+
+func sortMigrations(list []*Migration) []*Migration {
+
+	sorted := append([]*Migration{}, list...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return sorted
+}
+
+func migrate(db *gorm.DB, list []*Migration) ([]*Migration, error) {
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []*Migration{}
+	for _, migration := range sortMigrations(list) {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		// 迁移和迁移记录在同一个事务中提交，MySQL中的DDL语句会隐式提交事务，因此迁移需要可以重复执行
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&models.SchemaMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}); err != nil {
+			return done, fmt.Errorf("执行数据库迁移%d(%s)失败: %w", migration.Version, migration.Name, err)
+		}
+
+		log.Printf("已经执行数据库迁移%d(%s)", migration.Version, migration.Name)
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+func rollback(db *gorm.DB, list []*Migration, steps int) ([]*Migration, error) {
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := sortMigrations(list)
+
+	done := []*Migration{}
+	for i := len(sorted) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := sorted[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if migration.Down == nil {
+			return done, fmt.Errorf("数据库迁移%d(%s)%w", migration.Version, migration.Name, fileerr.ErrMigrationIrreversible)
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&models.SchemaMigration{Version: migration.Version}).Error
+		}); err != nil {
+			return done, fmt.Errorf("回滚数据库迁移%d(%s)失败: %w", migration.Version, migration.Name, err)
+		}
+
+		log.Printf("已经回滚数据库迁移%d(%s)", migration.Version, migration.Name)
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+func status(db *gorm.DB, list []*Migration) ([]*MigrationStatus, error) {
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []*MigrationStatus{}
+	for _, migration := range sortMigrations(list) {
+		migrationStatus := &MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+		if record, ok := applied[migration.Version]; ok {
+			migrationStatus.AppliedAt = &record.AppliedAt
+		}
+		statuses = append(statuses, migrationStatus)
+	}
+
+	// 数据库被更高版本的程序升级过时，会有当前程序中没有的迁移
+	for version, record := range applied {
+		if !hasVersion(list, version) {
+			statuses = append(statuses, &MigrationStatus{
+				Version:   record.Version,
+				Name:      record.Name,
+				AppliedAt: &record.AppliedAt,
+			})
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+func hasVersion(list []*Migration, version int) bool {
+	for _, migration := range list {
+		if migration.Version == version {
+			return true
+		}
+	}
+	return false
+}
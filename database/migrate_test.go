@@ -0,0 +1,147 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/lixiaofei123/nextlist/configs"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"github.com/lixiaofei123/nextlist/models"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+
+	db, err := Open(&configs.DataBase{
+		Type:   SQLITE,
+		Sqlite: configs.Sqlite{Path: filepath.Join(t.TempDir(), "nextlist.db")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+type testNote struct {
+	ID   int
+	Text string
+}
+
+func Test_MigrateAndRollback(t *testing.T) {
+
+	db := newTestDB(t)
+
+	list := []*Migration{
+		{
+			Version: 2,
+			Name:    "add_note",
+			Up: func(tx *gorm.DB) error {
+				return tx.Create(&testNote{ID: 1, Text: "hello"}).Error
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Delete(&testNote{ID: 1}).Error
+			},
+		},
+		{
+			Version: 1,
+			Name:    "create_notes",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&testNote{})
+			},
+		},
+	}
+
+	done, err := migrate(db, list)
+	if err != nil || len(done) != 2 || done[0].Version != 1 {
+		t.Fatalf("unexpected migrate result %v %v", done, err)
+	}
+
+	// 已经执行过的迁移不会重复执行
+	if done, err := migrate(db, list); err != nil || len(done) != 0 {
+		t.Fatalf("unexpected migrate result %v %v", done, err)
+	}
+
+	done, err = rollback(db, list, 1)
+	if err != nil || len(done) != 1 || done[0].Version != 2 {
+		t.Fatalf("unexpected rollback result %v %v", done, err)
+	}
+
+	var count int64
+	if err := db.Model(&testNote{}).Count(&count).Error; err != nil || count != 0 {
+		t.Fatalf("unexpected count %d %v", count, err)
+	}
+
+	statuses, err := status(db, list)
+	if err != nil || len(statuses) != 2 || statuses[0].AppliedAt == nil || statuses[1].AppliedAt != nil {
+		t.Fatalf("unexpected status %v %v", statuses, err)
+	}
+
+	if _, err := rollback(db, list, 1); !errors.Is(err, fileerr.ErrMigrationIrreversible) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func findMigration(t *testing.T, version int) *Migration {
+	for _, migration := range migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	t.Fatalf("migration %d not found", version)
+	return nil
+}
+
+func Test_RollbackMigrations(t *testing.T) {
+
+	db := newTestDB(t)
+
+	done, err := Migrate(db)
+	if err != nil || len(done) != len(migrations) {
+		t.Fatalf("unexpected migrate result %v %v", done, err)
+	}
+
+	// 回滚到第一个不能回滚的迁移为止
+	done, err = Rollback(db, 3)
+	if !errors.Is(err, fileerr.ErrMigrationIrreversible) || len(done) != 2 || done[1].Version != 7 {
+		t.Fatalf("unexpected rollback result %v %v", done, err)
+	}
+	if db.Migrator().HasColumn(&models.File{}, "SyncGen") {
+		t.Fatal("sync_gen should be dropped")
+	}
+
+	if done, err := Migrate(db); err != nil || len(done) != 2 {
+		t.Fatalf("unexpected migrate result %v %v", done, err)
+	}
+	if !db.Migrator().HasColumn(&models.File{}, "SyncGen") {
+		t.Fatal("sync_gen should be added")
+	}
+
+	// 2和3不在最新的版本上，直接执行它们的Down和Up
+	for _, version := range []int{2, 3} {
+		migration := findMigration(t, version)
+		if err := db.Transaction(migration.Down); err != nil {
+			t.Fatalf("rollback %d failed %v", version, err)
+		}
+		if err := db.Transaction(migration.Up); err != nil {
+			t.Fatalf("migrate %d failed %v", version, err)
+		}
+	}
+
+	if err := db.Transaction(findMigration(t, 2).Down); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Migrator().HasIndex(&models.File{}, "idx_parent_name") {
+		t.Fatal("idx_parent_name should be created")
+	}
+
+	// 已经保存了bcrypt哈希时不能缩短密码列
+	if err := db.Create(&models.User{ID: "1", UserName: "alice", Email: "alice@nextlist.com", Tel: "13800000000",
+		Password: "$2a$10$abcdefghijklmnopqrstuvwxyzabcdefghijklmnopqrstuvwxyzabc"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Transaction(findMigration(t, 3).Down); err == nil {
+		t.Fatal("narrowing password should fail")
+	}
+}
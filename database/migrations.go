@@ -0,0 +1,330 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lixiaofei123/nextlist/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// 迁移中使用的是表结构在对应版本时的快照，不能直接使用models中的模型，
+// 否则模型的修改会改变已经发布的迁移。以后对表结构的修改都需要追加新的迁移
+
+// 以下是第一个迁移创建表时的表结构
+type fileV1 struct {
+	ID             string         `gorm:"primaryKey,size:36"`
+	UserName       string         `gorm:"size:20"`
+	Name           string         `gorm:"size:200;not null;uniqueIndex:idx_parent_name_trash"`
+	ParentId       string         `gorm:"size:36;default:'';uniqueIndex:idx_parent_name_trash"`
+	AbsolutePath   string         `gorm:"size:300;not null;"`
+	IsDict         sql.NullBool   `gorm:"not null;default:false"`
+	FileType       string         `gorm:"size:100;not null;default:''"`
+	FileSize       int64          `gorm:"not null;default:0"`
+	Hash           string         `gorm:"size:128;not null;default:''"`
+	Permission     int            `gorm:"not null;default:0"`
+	FileStatus     int            `gorm:"not null"`
+	LastModifyTime time.Time      `gorm:"not null;"`
+	Password       string         `gorm:"size:30"`
+	DeletedAt      gorm.DeletedAt `gorm:"index"`
+	TrashId        string         `gorm:"size:36;not null;default:'';index;uniqueIndex:idx_parent_name_trash"`
+}
+
+func (fileV1) TableName() string { return "files" }
+
+type userV1 struct {
+	ID        string `gorm:"primaryKey,size:36"`
+	UserName  string `gorm:"size:20;uniqueIndex:idx_username"`
+	ShowName  string `gorm:"size:40"`
+	Email     string `gorm:"size:40;uniqueIndex:idx_email"`
+	Tel       string `gorm:"size:11;uniqueIndex:idx_tel"`
+	Password  string `gorm:"size:32"`
+	Role      string `gorm:"size:15"`
+	Enable    sql.NullBool
+	CreatedAt time.Time
+}
+
+func (userV1) TableName() string { return "users" }
+
+type shareV1 struct {
+	ID           string `gorm:"primaryKey,size:36"`
+	UserName     string `gorm:"size:20;index"`
+	FileId       string `gorm:"size:36;not null"`
+	ExpireAt     *time.Time
+	MaxDownloads int    `gorm:"not null;default:0"`
+	Downloads    int    `gorm:"not null;default:0"`
+	Password     string `gorm:"size:30"`
+	CreatedAt    time.Time
+}
+
+func (shareV1) TableName() string { return "shares" }
+
+type syncJobV1 struct {
+	ID        string       `gorm:"primaryKey,size:36"`
+	UserName  string       `gorm:"size:20"`
+	Path      string       `gorm:"size:300;not null"`
+	Spec      string       `gorm:"size:100;not null"`
+	Enable    sql.NullBool `gorm:"not null;default:true"`
+	CreatedAt time.Time
+}
+
+func (syncJobV1) TableName() string { return "sync_jobs" }
+
+type syncRunV1 struct {
+	ID      string    `gorm:"primaryKey,size:36"`
+	JobId   string    `gorm:"size:36;index"`
+	TaskId  string    `gorm:"size:36"`
+	StartAt time.Time `gorm:"not null"`
+	EndAt   *time.Time
+	Scanned int    `gorm:"not null;default:0"`
+	Added   int    `gorm:"not null;default:0"`
+	Updated int    `gorm:"not null;default:0"`
+	Removed int    `gorm:"not null;default:0"`
+	Error   string `gorm:"size:1000"`
+}
+
+func (syncRunV1) TableName() string { return "sync_runs" }
+
+type multipartUploadV1 struct {
+	ID        string    `gorm:"primaryKey,size:36"`
+	UserName  string    `gorm:"size:20"`
+	FileId    string    `gorm:"size:36;index"`
+	Key       string    `gorm:"size:300;not null"`
+	UploadId  string    `gorm:"size:1024;not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (multipartUploadV1) TableName() string { return "multipart_uploads" }
+
+// fileParentName 是回收站出现之前files表上的唯一索引
+type fileParentName struct {
+	Name     string `gorm:"size:200;not null;uniqueIndex:idx_parent_name"`
+	ParentId string `gorm:"size:36;default:'';uniqueIndex:idx_parent_name"`
+}
+
+func (fileParentName) TableName() string { return "files" }
+
+// userPassword 和 userPasswordV1 是users.password加宽前后的结构
+type userPassword struct {
+	Password string `gorm:"size:100"`
+}
+
+func (userPassword) TableName() string { return "users" }
+
+type userPasswordV1 struct {
+	Password string `gorm:"size:32"`
+}
+
+func (userPasswordV1) TableName() string { return "users" }
+
+type filePassword struct {
+	Password string `gorm:"size:100"`
+}
+
+func (filePassword) TableName() string { return "files" }
+
+type sharePassword struct {
+	Password string `gorm:"size:100"`
+}
+
+func (sharePassword) TableName() string { return "shares" }
+
+type fileSyncGen struct {
+	SyncGen int64 `gorm:"not null;default:0"`
+}
+
+func (fileSyncGen) TableName() string { return "files" }
+
+type fileTrashKey struct {
+	TrashKey string `gorm:"size:300;not null;default:''"`
+}
+
+func (fileTrashKey) TableName() string { return "files" }
+
+// migrations 是所有的数据库迁移，新的迁移只能追加在最后
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "create_tables",
+		Up: func(tx *gorm.DB) error {
+			// 早期版本中permission和file_status的标签写错了，这两列允许为空，改为不允许为空之前需要先填充数据
+			if tx.Migrator().HasTable(&fileV1{}) {
+				if err := tx.Table("files").Where("permission is null").Update("permission", models.PUBLICREAD).Error; err != nil {
+					return err
+				}
+				if err := tx.Table("files").Where("file_status is null").Update("file_status", models.SUCCESS).Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.AutoMigrate(
+				&userV1{},
+				&fileV1{},
+				&shareV1{},
+				&syncJobV1{},
+				&syncRunV1{},
+				&multipartUploadV1{},
+			)
+		},
+	},
+	{
+		Version: 2,
+		Name:    "drop_idx_parent_name",
+		Up: func(tx *gorm.DB) error {
+			// 回收站中的文件允许重名，原来的唯一索引已经被idx_parent_name_trash代替
+			if tx.Migrator().HasIndex(&fileParentName{}, "idx_parent_name") {
+				return tx.Migrator().DropIndex(&fileParentName{}, "idx_parent_name")
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			// 回收站中有重名的文件时无法重新创建唯一索引，需要先清空回收站
+			if tx.Migrator().HasIndex(&fileParentName{}, "idx_parent_name") {
+				return nil
+			}
+			return tx.Migrator().CreateIndex(&fileParentName{}, "idx_parent_name")
+		},
+	},
+	{
+		Version: 3,
+		Name:    "widen_user_password",
+		Up: func(tx *gorm.DB) error {
+			// 密码改为保存bcrypt哈希，原来的32个字符只够保存MD5
+			return tx.Migrator().AlterColumn(&userPassword{}, "Password")
+		},
+		Down: func(tx *gorm.DB) error {
+			var count int64
+			if err := tx.Table("users").Where("length(password) > ?", 32).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("有%d个用户的密码已经是bcrypt哈希，无法缩短password列", count)
+			}
+			return tx.Migrator().AlterColumn(&userPasswordV1{}, "Password")
+		},
+	},
+	{
+		Version: 4,
+		Name:    "hash_file_passwords",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AlterColumn(&filePassword{}, "Password"); err != nil {
+				return err
+			}
+
+			// 子文件会复制父文件夹的密码，相同的密码只需要计算一次哈希
+			passwords := []string{}
+			if err := tx.Table("files").Distinct("password").
+				Where("password <> '' and password not like ?", "$2%").
+				Pluck("password", &passwords).Error; err != nil {
+				return err
+			}
+
+			for _, password := range passwords {
+				hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+				if err != nil {
+					return err
+				}
+				if err := tx.Table("files").Where("password = ?", password).
+					Update("password", string(hash)).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "promote_first_user",
+		Up: func(tx *gorm.DB) error {
+			// 以前注册的用户都是普通用户，没有超级管理员时把最早注册的用户设置为超级管理员
+			var count int64
+			if err := tx.Table("users").Where("role = ?", models.SuperAdminRole).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+
+			ids := []string{}
+			if err := tx.Table("users").Order("created_at").Limit(1).Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+
+			return tx.Table("users").Where("id = ?", ids[0]).Update("role", models.SuperAdminRole).Error
+		},
+	},
+	{
+		Version: 6,
+		Name:    "hash_share_passwords",
+		Up: func(tx *gorm.DB) error {
+			if err := tx.Migrator().AlterColumn(&sharePassword{}, "Password"); err != nil {
+				return err
+			}
+
+			shares := []*struct {
+				ID       string
+				Password string
+			}{}
+			if err := tx.Table("shares").Where("password <> '' and password not like ?", "$2%").Find(&shares).Error; err != nil {
+				return err
+			}
+
+			for _, share := range shares {
+				hash, err := bcrypt.GenerateFromPassword([]byte(share.Password), bcrypt.DefaultCost)
+				if err != nil {
+					return err
+				}
+				if err := tx.Table("shares").Where("id = ?", share.ID).Update("password", string(hash)).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_file_sync_gen",
+		Up: func(tx *gorm.DB) error {
+			// 同步时在访问过的记录上标记本次同步的编号，不再需要在内存中保存所有的路径
+			if tx.Migrator().HasColumn(&fileSyncGen{}, "SyncGen") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&fileSyncGen{}, "SyncGen")
+		},
+		Down: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasColumn(&fileSyncGen{}, "SyncGen") {
+				return nil
+			}
+			return tx.Migrator().DropColumn(&fileSyncGen{}, "SyncGen")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_file_trash_key",
+		Up: func(tx *gorm.DB) error {
+			// 放入回收站的文件在存储中会被移动到单独的位置，避免被之后上传到原来位置的文件覆盖
+			if tx.Migrator().HasColumn(&fileTrashKey{}, "TrashKey") {
+				return nil
+			}
+			return tx.Migrator().AddColumn(&fileTrashKey{}, "TrashKey")
+		},
+		Down: func(tx *gorm.DB) error {
+			// 回收站中的文件已经不在原来的位置上，回滚后无法再还原
+			var count int64
+			if err := tx.Table("files").Where("trash_key <> ''").Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("回收站中有%d个文件保存在单独的位置，请先清空回收站", count)
+			}
+			return tx.Migrator().DropColumn(&fileTrashKey{}, "TrashKey")
+		},
+	},
+}
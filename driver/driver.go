@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"path"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
@@ -17,6 +19,9 @@ type File struct {
 	Childrens    []*File
 	Size         int64
 	AbsolutePath string
+	ModTime      time.Time
+	// Hash 是存储返回的ETag或者哈希值，存储不提供时为空
+	Hash string
 }
 
 type Driver interface {
@@ -30,11 +35,117 @@ type Driver interface {
 
 	WalkDir(key string) (*File, error)
 
+	Walk(key string, fn WalkFunc) error
+
 	PreDeleteUrl(key string) (string, error)
 
 	DownloadUrl(key string) ([]*DownloadUrl, error)
 }
 
+// WalkFunc 在遍历存储时每找到一个文件(夹)就调用一次，父文件夹总是先于其中的文件被调用，
+// file中不包含Childrens，返回错误时会停止遍历
+type WalkFunc func(file *File) error
+
+// formatKey 将路径格式化为以/开头并且不以/结尾的形式，根目录为/
+func formatKey(key string) string {
+	return "/" + strings.Trim(key, "/")
+}
+
+// walkDir 通过Walk构建出key目录下完整的文件树，文件很多时会占用大量的内存
+func walkDir(walk func(key string, fn WalkFunc) error, key string) (*File, error) {
+
+	key = formatKey(key)
+
+	root := &File{
+		Name:         path.Base(key),
+		IsDir:        true,
+		AbsolutePath: key,
+		Childrens:    []*File{},
+	}
+
+	dirs := map[string]*File{key: root}
+
+	err := walk(key, func(file *File) error {
+		if parent, ok := dirs[path.Dir(file.AbsolutePath)]; ok {
+			parent.Childrens = append(parent.Childrens, file)
+		}
+		if file.IsDir {
+			file.Childrens = []*File{}
+			dirs[file.AbsolutePath] = file
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// Mover 是驱动可以选择实现的接口，用于在存储中移动或者重命名文件以及文件夹。
+// 移动中途失败时，用同样的参数再次调用Move需要能够完成剩下的部分
+type Mover interface {
+	Move(src string, dst string) error
+}
+
+// Copier 是驱动可以选择实现的接口，用于在存储中直接复制文件以及文件夹
+type Copier interface {
+	Copy(src string, dst string) error
+}
+
+// Deleter 是驱动可以选择实现的接口，用于在服务端直接删除存储中的文件，文件不存在时不返回错误
+type Deleter interface {
+	Delete(key string) error
+}
+
+// DirRemover 是驱动可以选择实现的接口，用于删除存储中的空文件夹，文件夹不存在或者不为空时不返回错误
+type DirRemover interface {
+	RemoveDir(key string) error
+}
+
+// Stater 是驱动可以选择实现的接口，用于获取存储中单个文件(夹)的信息，
+// 文件不存在时返回的错误满足errors.Is(err, fs.ErrNotExist)
+type Stater interface {
+	Stat(key string) (*File, error)
+}
+
+// Resolver 是组合了多个存储的驱动实现的接口，返回key实际所在的驱动以及key在这个驱动中的路径
+type Resolver interface {
+	DriverFor(key string) (Driver, string, error)
+}
+
+// Resolve 返回key实际所在的驱动，组合驱动总是实现了所有可选的接口，
+// 需要通过实际的驱动来判断这个路径是否支持某个操作
+func Resolve(d Driver, key string) (Driver, string, error) {
+	if resolver, ok := d.(Resolver); ok {
+		return resolver.DriverFor(key)
+	}
+	return d, key, nil
+}
+
+// Putter 是驱动可以选择实现的接口，用于在服务端把数据直接写入存储，size为数据的长度
+type Putter interface {
+	Put(key string, body io.Reader, size int64) error
+}
+
+// MultipartUploader 是驱动可以选择实现的接口，用于分片上传大文件，每个分片由客户端通过签名链接直接上传到存储
+type MultipartUploader interface {
+	CreateMultipartUpload(key string) (string, error)
+
+	PreUploadPartUrl(key string, uploadId string, partNumber int) (string, error)
+
+	CompleteMultipartUpload(key string, uploadId string, parts []*UploadPart) error
+
+	AbortMultipartUpload(key string, uploadId string) error
+}
+
+// UploadPart 是已经上传完成的分片，ETag为上传分片时存储返回的ETag
+type UploadPart struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
 type DriveConfig interface {
 }
 
@@ -65,6 +176,11 @@ type DriveConfigProp struct {
 	Properties []Property `json:"properties"`
 }
 
+// routePrefixer 同一种驱动挂载多次时，每个实例的上传以及下载接口需要使用不同的路径前缀
+type routePrefixer interface {
+	setRoutePrefix(prefix string)
+}
+
 var drivers map[string]Driver = map[string]Driver{}
 var driveConfigs map[string]DriveConfig = map[string]DriveConfig{}
 var driveProps map[string]DriveConfigProp = map[string]DriveConfigProp{}
@@ -92,6 +208,7 @@ func GetDriverProps() map[string]DriveConfigProp {
 	return driveProps
 }
 
+// GetDriver 每次调用都会创建新的驱动实例，同一种驱动可以使用不同的配置挂载多次
 func GetDriver(name string, config map[string]interface{}) (Driver, error) {
 
 	data, err := json.Marshal(config)
@@ -99,17 +216,19 @@ func GetDriver(name string, config map[string]interface{}) (Driver, error) {
 		return nil, err
 	}
 
-	driverconfig, ok := driveConfigs[name]
+	driveConfig, ok := driveConfigs[name]
 	if !ok {
 		return nil, errors.New("未找到合适的存储驱动")
 	}
 
+	driverconfig := reflect.New(reflect.TypeOf(driveConfig).Elem()).Interface()
 	err = json.Unmarshal(data, driverconfig)
 	if err != nil {
 		return nil, errors.New("存储配置错误")
 	}
 
-	if driver, ok := drivers[name]; ok {
+	if registered, ok := drivers[name]; ok {
+		driver := reflect.New(reflect.TypeOf(registered).Elem()).Interface().(Driver)
 		driver.initConfig(driverconfig)
 		return driver, nil
 	}
@@ -8,12 +8,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/labstack/echo/v4"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
 	"github.com/lixiaofei123/nextlist/utils"
 	"gorm.io/gorm"
 )
@@ -34,6 +34,11 @@ type FileDriverConfig struct {
 type FileDriver struct {
 	config FileDriverConfig
 	path   string
+	prefix string
+}
+
+func (d *FileDriver) setRoutePrefix(prefix string) {
+	d.prefix = prefix
 }
 
 func (d *FileDriver) initConfig(config interface{}) error {
@@ -62,29 +67,14 @@ func (d *FileDriver) Check() error {
 
 func (d *FileDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
 
-	e.PUT("/driver/file", func(ctx echo.Context) error {
+	e.PUT(d.prefix+"/driver/file", func(ctx echo.Context) error {
 
 		filepath := utils.GetValue(ctx, "path")
 
 		body := ctx.Request().Body
 		defer body.Close()
 
-		absPath := path.Join(d.path, filepath)
-		dir := path.Dir(absPath)
-
-		err := os.MkdirAll(dir, 0751)
-		if err != nil {
-			return err
-		}
-
-		dstFile, err := os.Create(absPath)
-		if err != nil {
-			return err
-		}
-
-		defer dstFile.Close()
-
-		_, err = io.Copy(dstFile, body)
+		err := d.Put(filepath, body, ctx.Request().ContentLength)
 		if err != nil {
 			return err
 		}
@@ -94,40 +84,52 @@ func (d *FileDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
 		return nil
 	}, checkSignHandler(d.config.Key))
 
-	e.DELETE("/driver/file", func(ctx echo.Context) error {
+	e.DELETE(d.prefix+"/driver/file", func(ctx echo.Context) error {
 
 		filepath := utils.GetValue(ctx, "path")
-		absPath := path.Join(d.path, filepath)
-		os.Remove(absPath)
+		d.Delete(filepath)
 		ctx.Response().Status = http.StatusOK
 		return nil
 	}, checkSignHandler(d.config.Key))
 
-	e.GET("/driver/file", func(ctx echo.Context) error {
+	e.GET(d.prefix+"/driver/file", func(ctx echo.Context) error {
 
 		filepath := utils.GetValue(ctx, "path")
 		absPath := path.Join(d.path, filepath)
 
-		data, err := os.ReadFile(absPath)
+		file, err := os.Open(absPath)
 		if err != nil {
 			return err
 		}
+		defer file.Close()
 
-		ctx.Response().Status = http.StatusOK
-		length := len(data)
-		ctx.Response().Header().Add("Content-Length", strconv.Itoa(length))
-		ctx.Response().Header().Add("Content-Disposition", fmt.Sprintf("attachment;filename=%s", path.Base(filepath)))
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
 
-		mtype := mimetype.Detect(data)
-		if mtype != nil {
-			ctx.Response().Header().Add("Content-Type", mtype.String())
+		if info.IsDir() {
+			return fileerr.ErrIsDirectory
 		}
 
-		_, err = ctx.Response().Write(data)
-		if err != nil {
+		name := path.Base(filepath)
+		header := ctx.Response().Header()
+		header.Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", name))
+		header.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+
+		// 只读取文件开头的一小部分来判断文件类型
+		mtype, err := mimetype.DetectReader(file)
+		if err == nil && mtype != nil {
+			header.Set("Content-Type", mtype.String())
+		}
+
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
 			return err
 		}
 
+		// ServeContent会处理Range、If-None-Match以及If-Modified-Since，不需要把整个文件读到内存中
+		http.ServeContent(ctx.Response(), ctx.Request(), name, info.ModTime(), file)
+
 		return nil
 	}, checkSignHandler(d.config.Key))
 
@@ -135,22 +137,12 @@ func (d *FileDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
 }
 
 func (d *FileDriver) WalkDir(key string) (*File, error) {
+	return walkDir(d.Walk, key)
+}
 
-	key = strings.TrimRight(key, "/")
-	if key == "" {
-		key = "/"
-	}
-
-	absPath := path.Join(d.path, key)
-
-	var temp map[string]*File = map[string]*File{}
+func (d *FileDriver) Walk(key string, fn WalkFunc) error {
 
-	root := &File{
-		Name:         filepath.Base(key),
-		IsDir:        true,
-		AbsolutePath: key,
-		Childrens:    []*File{},
-	}
+	key = formatKey(key)
 
 	formatPath := func(path string) string {
 		path = strings.TrimPrefix(path, d.path)
@@ -164,44 +156,37 @@ func (d *FileDriver) WalkDir(key string) (*File, error) {
 		return path
 	}
 
-	temp[formatPath(key)] = root
-
-	filepath.Walk(absPath, func(path string, info fs.FileInfo, err error) error {
+	// 不完整的目录列表会导致同步时误删文件，因此遍历出错时直接返回错误
+	return filepath.Walk(path.Join(d.path, key), func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
 		path = formatPath(path)
-		if key != path {
-			file := &File{
-				Name:         filepath.Base(path),
-				AbsolutePath: path,
-			}
-			if info.IsDir() {
-				file.IsDir = true
-				file.Childrens = []*File{}
-				temp[path] = file
-			} else {
-				file.IsDir = false
-				file.Size = info.Size()
-			}
-
-			cacheKey := formatPath(filepath.Dir(path))
-			if dirFile, ok := temp[cacheKey]; ok {
-				dirFile.Childrens = append(dirFile.Childrens, file)
-			}
-
+		if path == key {
+			return nil
 		}
-		return nil
-	})
 
-	return root, nil
+		file := &File{
+			Name:         filepath.Base(path),
+			AbsolutePath: path,
+			IsDir:        info.IsDir(),
+			ModTime:      info.ModTime(),
+		}
+		if !file.IsDir {
+			file.Size = info.Size()
+		}
 
+		return fn(file)
+	})
 }
 
 func (d *FileDriver) PreUploadUrl(path string) (string, error) {
 
-	return signUrl(fmt.Sprintf("%s/api/v1/driver/file", d.config.Host), d.config.Key, path, time.Hour*2)
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/file", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
 }
 
 func (d *FileDriver) PreDeleteUrl(path string) (string, error) {
-	return signUrl(fmt.Sprintf("%s/api/v1/driver/file", d.config.Host), d.config.Key, path, time.Hour*2)
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/file", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
 }
 
 func (d *FileDriver) DownloadUrl(path string) ([]*DownloadUrl, error) {
@@ -214,7 +199,7 @@ func (d *FileDriver) DownloadUrl(path string) ([]*DownloadUrl, error) {
 			DownloadUrl: fmt.Sprintf("%s/upload/%s", d.config.Host, path),
 		})
 	} else {
-		downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1/driver/file", d.config.Host), d.config.Key, path, time.Hour*2)
+		downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1%s/driver/file", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
 
 		if err == nil {
 			downloadUrls = append(downloadUrls, &DownloadUrl{
@@ -226,3 +211,138 @@ func (d *FileDriver) DownloadUrl(path string) ([]*DownloadUrl, error) {
 
 	return downloadUrls, nil
 }
+
+func (d *FileDriver) Stat(key string) (*File, error) {
+
+	key = formatKey(key)
+
+	info, err := os.Stat(path.Join(d.path, key))
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{
+		Name:         path.Base(key),
+		AbsolutePath: key,
+		IsDir:        info.IsDir(),
+		ModTime:      info.ModTime(),
+	}
+	if !file.IsDir {
+		file.Size = info.Size()
+	}
+
+	return file, nil
+}
+
+func (d *FileDriver) Put(key string, body io.Reader, size int64) error {
+
+	absPath := path.Join(d.path, key)
+
+	err := os.MkdirAll(path.Dir(absPath), 0751)
+	if err != nil {
+		return err
+	}
+
+	dstFile, err := os.Create(absPath)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	_, err = io.Copy(dstFile, body)
+	return err
+}
+
+func (d *FileDriver) Delete(key string) error {
+
+	err := os.Remove(path.Join(d.path, key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveDir 只删除空文件夹，文件夹中还有不在数据库中的文件时会被保留
+func (d *FileDriver) RemoveDir(key string) error {
+
+	dirPath := path.Join(d.path, key)
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if len(entries) > 0 {
+		return nil
+	}
+
+	err = os.Remove(dirPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (d *FileDriver) Move(src string, dst string) error {
+
+	srcPath := path.Join(d.path, src)
+	dstPath := path.Join(d.path, dst)
+
+	// 只存在于数据库中的空文件夹在磁盘上没有对应的目录
+	if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := os.MkdirAll(path.Dir(dstPath), 0751)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(srcPath, dstPath)
+}
+
+func (d *FileDriver) Copy(src string, dst string) error {
+
+	srcPath := path.Join(d.path, src)
+	dstPath := path.Join(d.path, dst)
+
+	if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	return filepath.Walk(srcPath, func(filePath string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target := dstPath + strings.TrimPrefix(filePath, srcPath)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0751)
+		}
+
+		err = os.MkdirAll(path.Dir(target), 0751)
+		if err != nil {
+			return err
+		}
+
+		srcFile, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer srcFile.Close()
+
+		dstFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode())
+		if err != nil {
+			return err
+		}
+		defer dstFile.Close()
+
+		_, err = io.Copy(dstFile, srcFile)
+		return err
+	})
+}
@@ -3,7 +3,14 @@ package driver
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
 )
 
 func Test_FileWalkDir(t *testing.T) {
@@ -21,3 +28,47 @@ func Test_FileWalkDir(t *testing.T) {
 	data, _ := json.Marshal(f)
 	fmt.Println(string(data))
 }
+
+func Test_FileDownload(t *testing.T) {
+
+	fdriver := &FileDriver{}
+	fdriver.initConfig(&FileDriverConfig{
+		Path: t.TempDir(),
+		Key:  "key",
+	})
+
+	if err := ioutil.WriteFile(filepath.Join(fdriver.path, "video.mp4"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	fdriver.InitDriver(e.Group("/api/v1"), nil)
+
+	signed, err := signUrl("/api/v1/driver/file", fdriver.config.Key, "/video.mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, signed, nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "234" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expect etag")
+	}
+
+	req = httptest.NewRequest(http.MethodGet, signed, nil)
+	req.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("unexpected response %d", rec.Code)
+	}
+}
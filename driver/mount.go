@@ -0,0 +1,398 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"gorm.io/gorm"
+)
+
+// Mount 将一个驱动挂载到虚拟路径下，挂载路径下的所有文件都保存在这个驱动中
+type Mount struct {
+	Path   string
+	Driver Driver
+}
+
+// MountDriver 将多个驱动组合成一个驱动，根据文件所在的路径把请求转发给对应的驱动，
+// 转发时会去掉路径中的挂载路径
+type MountDriver struct {
+	// 按照挂载路径从长到短排序，查找时优先匹配更深的挂载点
+	mounts []*Mount
+}
+
+func NewMountDriver(mounts []*Mount) (*MountDriver, error) {
+
+	if len(mounts) == 0 {
+		return nil, errors.New("没有挂载任何存储")
+	}
+
+	paths := map[string]bool{}
+	types := map[reflect.Type]int{}
+	for _, mount := range mounts {
+		mount.Path = formatKey(mount.Path)
+		if paths[mount.Path] {
+			return nil, errors.New("挂载路径" + mount.Path + "重复")
+		}
+		paths[mount.Path] = true
+
+		// 同一种驱动注册的接口路径是相同的，从第二个实例开始加上前缀来区分
+		driverType := reflect.TypeOf(mount.Driver)
+		if prefixer, ok := mount.Driver.(routePrefixer); ok && types[driverType] > 0 {
+			prefixer.setRoutePrefix(fmt.Sprintf("/mount/%d", types[driverType]))
+		}
+		types[driverType]++
+	}
+
+	sort.Slice(mounts, func(i, j int) bool {
+		return len(mounts[i].Path) > len(mounts[j].Path)
+	})
+
+	return &MountDriver{
+		mounts: mounts,
+	}, nil
+}
+
+// find 返回key所在的挂载点以及key在挂载的驱动中的路径
+func (d *MountDriver) find(key string) (*Mount, string, error) {
+
+	key = formatKey(key)
+	for _, mount := range d.mounts {
+		if mount.Path == "/" {
+			return mount, key, nil
+		}
+		if key == mount.Path || strings.HasPrefix(key, mount.Path+"/") {
+			return mount, formatKey(strings.TrimPrefix(key, mount.Path)), nil
+		}
+	}
+
+	return nil, "", fileerr.ErrNotMounted
+}
+
+func (d *MountDriver) DriverFor(key string) (Driver, string, error) {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return mount.Driver, key, nil
+}
+
+func (d *MountDriver) Check() error {
+	for _, mount := range d.mounts {
+		if err := mount.Driver.Check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *MountDriver) initConfig(config interface{}) error {
+	return nil
+}
+
+func (d *MountDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
+	for _, mount := range d.mounts {
+		if err := mount.Driver.InitDriver(e, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *MountDriver) PreUploadUrl(key string) (string, error) {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return "", err
+	}
+
+	return mount.Driver.PreUploadUrl(key)
+}
+
+func (d *MountDriver) PreDeleteUrl(key string) (string, error) {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return "", err
+	}
+
+	return mount.Driver.PreDeleteUrl(key)
+}
+
+func (d *MountDriver) DownloadUrl(key string) ([]*DownloadUrl, error) {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return mount.Driver.DownloadUrl(key)
+}
+
+func (d *MountDriver) WalkDir(key string) (*File, error) {
+	return walkDir(d.Walk, key)
+}
+
+// Walk 先遍历key所在的驱动，再遍历挂载在key下面的其它驱动，挂载点以及它的上级文件夹会作为文件夹返回
+func (d *MountDriver) Walk(key string, fn WalkFunc) error {
+
+	key = formatKey(key)
+
+	// 挂载点的上级文件夹可能已经在遍历驱动的时候返回过了
+	emitted := map[string]bool{}
+	isMountParent := func(p string) bool {
+		for _, mount := range d.mounts {
+			if strings.HasPrefix(mount.Path, p+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	walkMount := func(mount *Mount, inner string) error {
+		return mount.Driver.Walk(inner, func(file *File) error {
+			file.AbsolutePath = path.Join(mount.Path, file.AbsolutePath)
+			// 被更深的挂载点覆盖的文件不返回
+			if owner, _, err := d.find(file.AbsolutePath); err != nil || owner != mount {
+				return nil
+			}
+			if file.IsDir && isMountParent(file.AbsolutePath) {
+				emitted[file.AbsolutePath] = true
+			}
+			return fn(file)
+		})
+	}
+
+	owner, inner, err := d.find(key)
+	if err == nil {
+		// 只是挂载点的上级文件夹时，驱动中可以不存在这个文件夹
+		if err := walkMount(owner, inner); err != nil && !(errors.Is(err, fs.ErrNotExist) && isMountParent(key)) {
+			return err
+		}
+	}
+
+	// 按照路径从短到长遍历，保证上级文件夹先被返回
+	mounts := append([]*Mount{}, d.mounts...)
+	sort.Slice(mounts, func(i, j int) bool {
+		return len(mounts[i].Path) < len(mounts[j].Path)
+	})
+
+	found := err == nil
+	for _, mount := range mounts {
+		if mount == owner || !(key == "/" || strings.HasPrefix(mount.Path, key+"/")) {
+			continue
+		}
+		found = true
+
+		names := strings.Split(strings.Trim(strings.TrimPrefix(mount.Path, key), "/"), "/")
+		dir := key
+		for _, name := range names {
+			dir = path.Join(dir, name)
+			if emitted[dir] {
+				continue
+			}
+			emitted[dir] = true
+			if err := fn(&File{
+				Name:         name,
+				IsDir:        true,
+				AbsolutePath: dir,
+			}); err != nil {
+				return err
+			}
+		}
+
+		if err := walkMount(mount, "/"); err != nil {
+			return err
+		}
+	}
+
+	if !found {
+		return fileerr.ErrNotMounted
+	}
+
+	return nil
+}
+
+// Move 只能在同一个驱动中移动，挂载点本身不能被移动
+func (d *MountDriver) Move(src string, dst string) error {
+
+	srcMount, srcKey, err := d.find(src)
+	if err != nil {
+		return err
+	}
+
+	dstMount, dstKey, err := d.find(dst)
+	if err != nil {
+		return err
+	}
+
+	mover, ok := srcMount.Driver.(Mover)
+	if !ok || srcMount != dstMount || srcKey == "/" || dstKey == "/" {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	return mover.Move(srcKey, dstKey)
+}
+
+// Copy 只能在同一个驱动中复制
+func (d *MountDriver) Copy(src string, dst string) error {
+
+	srcMount, srcKey, err := d.find(src)
+	if err != nil {
+		return err
+	}
+
+	dstMount, dstKey, err := d.find(dst)
+	if err != nil {
+		return err
+	}
+
+	copier, ok := srcMount.Driver.(Copier)
+	if !ok || srcMount != dstMount || srcKey == "/" || dstKey == "/" {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	return copier.Copy(srcKey, dstKey)
+}
+
+func (d *MountDriver) Stat(key string) (*File, error) {
+
+	mount, inner, err := d.find(key)
+	if err != nil {
+		return nil, err
+	}
+
+	stater, ok := mount.Driver.(Stater)
+	if !ok {
+		return nil, fileerr.ErrUnSupportOperation
+	}
+
+	file, err := stater.Stat(inner)
+	if err != nil {
+		return nil, err
+	}
+	file.AbsolutePath = path.Join(mount.Path, file.AbsolutePath)
+	file.Name = path.Base(file.AbsolutePath)
+
+	return file, nil
+}
+
+func (d *MountDriver) Put(key string, body io.Reader, size int64) error {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return err
+	}
+
+	putter, ok := mount.Driver.(Putter)
+	if !ok {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	return putter.Put(key, body, size)
+}
+
+func (d *MountDriver) multipartUploader(key string) (MultipartUploader, string, error) {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uploader, ok := mount.Driver.(MultipartUploader)
+	if !ok {
+		return nil, "", fileerr.ErrUnSupportOperation
+	}
+
+	return uploader, key, nil
+}
+
+func (d *MountDriver) CreateMultipartUpload(key string) (string, error) {
+
+	uploader, key, err := d.multipartUploader(key)
+	if err != nil {
+		return "", err
+	}
+
+	return uploader.CreateMultipartUpload(key)
+}
+
+func (d *MountDriver) PreUploadPartUrl(key string, uploadId string, partNumber int) (string, error) {
+
+	uploader, key, err := d.multipartUploader(key)
+	if err != nil {
+		return "", err
+	}
+
+	return uploader.PreUploadPartUrl(key, uploadId, partNumber)
+}
+
+func (d *MountDriver) CompleteMultipartUpload(key string, uploadId string, parts []*UploadPart) error {
+
+	uploader, key, err := d.multipartUploader(key)
+	if err != nil {
+		return err
+	}
+
+	return uploader.CompleteMultipartUpload(key, uploadId, parts)
+}
+
+func (d *MountDriver) AbortMultipartUpload(key string, uploadId string) error {
+
+	uploader, key, err := d.multipartUploader(key)
+	if err != nil {
+		return err
+	}
+
+	return uploader.AbortMultipartUpload(key, uploadId)
+}
+
+// Delete 驱动不支持在服务端删除时返回ErrUnSupportOperation，调用方可以改为使用删除链接
+func (d *MountDriver) Delete(key string) error {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return err
+	}
+
+	// 挂载点只是一个虚拟的文件夹，不能删除整个驱动中的文件
+	if key == "/" {
+		return nil
+	}
+
+	deleter, ok := mount.Driver.(Deleter)
+	if !ok {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	return deleter.Delete(key)
+}
+
+func (d *MountDriver) RemoveDir(key string) error {
+
+	mount, key, err := d.find(key)
+	if err != nil {
+		return err
+	}
+
+	// 挂载点本身不能被删除
+	if key == "/" {
+		return nil
+	}
+
+	remover, ok := mount.Driver.(DirRemover)
+	if !ok {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	return remover.RemoveDir(key)
+}
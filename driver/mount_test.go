@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+)
+
+func Test_MountWalkAndMove(t *testing.T) {
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "docs", "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fdriver := &FileDriver{}
+	fdriver.initConfig(&FileDriverConfig{Path: root})
+
+	ddriver := newTestWebDavDriver(t)
+	if err := ddriver.Put("/b.txt", strings.NewReader("b"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	mdriver, err := NewMountDriver([]*Mount{
+		{Path: "/", Driver: fdriver},
+		{Path: "/cloud/dav", Driver: ddriver},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	if err := mdriver.Walk("/", func(file *File) error {
+		paths = append(paths, file.AbsolutePath)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := "/docs,/docs/a.txt,/cloud,/cloud/dav,/cloud/dav/b.txt"
+	if strings.Join(paths, ",") != expect {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+
+	sub, err := mdriver.WalkDir("/cloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.Childrens) != 1 || len(sub.Childrens[0].Childrens) != 1 {
+		t.Fatalf("unexpected dir %+v", sub)
+	}
+
+	if err := mdriver.Move("/cloud/dav/b.txt", "/cloud/dav/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不同驱动之间不能移动
+	if err := mdriver.Move("/docs/a.txt", "/cloud/dav/a.txt"); !errors.Is(err, fileerr.ErrUnSupportOperation) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// 删除挂载点不会删除驱动中的文件
+	if err := mdriver.Delete("/cloud/dav"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ddriver.listDir("/")
+	if err != nil || len(entries) != 1 || entries[0].Name != "c.txt" {
+		t.Fatalf("unexpected entries %v %v", entries, err)
+	}
+}
+
+func Test_MountSameDriverTwice(t *testing.T) {
+
+	roots := []string{t.TempDir(), t.TempDir()}
+	mounts := []*Mount{}
+	for i, root := range roots {
+		sdriver, err := GetDriver("file", map[string]interface{}{
+			"path": root,
+			"key":  "key",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mounts = append(mounts, &Mount{Path: []string{"/", "/backup"}[i], Driver: sdriver})
+	}
+
+	if mounts[0].Driver == mounts[1].Driver {
+		t.Fatal("expect different driver instances")
+	}
+
+	mdriver, err := NewMountDriver(mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	if err := mdriver.InitDriver(e.Group("/api/v1"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, key := range []string{"/a.txt", "/backup/a.txt"} {
+		signed, err := mdriver.PreUploadUrl(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPut, signed, strings.NewReader(key))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code >= http.StatusMultipleChoices {
+			t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(roots[i], "a.txt"))
+		if err != nil || string(data) != key {
+			t.Fatalf("unexpected file %s %v", data, err)
+		}
+	}
+}
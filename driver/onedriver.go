@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -42,6 +43,11 @@ type OneDriverConfig struct {
 type OneDriver struct {
 	config      OneDriverConfig
 	AccessToken string
+	prefix      string
+}
+
+func (d *OneDriver) setRoutePrefix(prefix string) {
+	d.prefix = prefix
 }
 
 func (d *OneDriver) Check() error {
@@ -75,7 +81,7 @@ func (d *OneDriver) initConfig(config interface{}) error {
 
 func (d *OneDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
 
-	e.PUT("/driver/onedriver", func(ctx echo.Context) error {
+	e.PUT(d.prefix+"/driver/onedriver", func(ctx echo.Context) error {
 
 		filepath := utils.GetValue(ctx, "path")
 		dataLength := utils.GetIntValueFromAnywhere(ctx, "Content-Length")
@@ -95,14 +101,14 @@ func (d *OneDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
 
 	}, checkSignHandler(d.config.Key))
 
-	e.DELETE("/driver/onedriver", func(ctx echo.Context) error {
+	e.DELETE(d.prefix+"/driver/onedriver", func(ctx echo.Context) error {
 
 		filepath := utils.GetValue(ctx, "path")
 		return d.Delete(filepath)
 
 	}, checkSignHandler(d.config.Key))
 
-	e.GET("/driver/onedriver", func(ctx echo.Context) error {
+	e.GET(d.prefix+"/driver/onedriver", func(ctx echo.Context) error {
 
 		filepath := utils.GetValue(ctx, "path")
 
@@ -122,7 +128,7 @@ func (d *OneDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
 
 type ODWalkFunc func(path string, file *ODFile) error
 
-func (d *OneDriver) Walk(path string, fn ODWalkFunc) error {
+func (d *OneDriver) walkItems(path string, fn ODWalkFunc) error {
 
 	odfiles, err := d.listDir(path)
 	if err != nil {
@@ -134,7 +140,7 @@ func (d *OneDriver) Walk(path string, fn ODWalkFunc) error {
 			return err
 		}
 		if odfile.IsDir {
-			err = d.Walk(filepath.Join(path, odfile.Name), fn)
+			err = d.walkItems(filepath.Join(path, odfile.Name), fn)
 			if err != nil {
 				return err
 			}
@@ -145,68 +151,40 @@ func (d *OneDriver) Walk(path string, fn ODWalkFunc) error {
 }
 
 func (d *OneDriver) WalkDir(key string) (*File, error) {
+	return walkDir(d.Walk, key)
+}
 
-	formatPath := func(path string) string {
-		path = strings.TrimRight(path, "/")
-		if path == "" {
-			return "/"
-		}
-		return path
-	}
-
-	key = formatPath(key)
-
-	var temp map[string]*File = map[string]*File{}
-	root := &File{
-		Name:         filepath.Base(key),
-		IsDir:        true,
-		AbsolutePath: key,
-		Childrens:    []*File{},
-	}
-
-	temp[key] = root
+func (d *OneDriver) Walk(key string, fn WalkFunc) error {
 
-	err := d.Walk(key, func(path string, odfile *ODFile) error {
+	return d.walkItems(formatKey(key), func(path string, odfile *ODFile) error {
 
 		file := &File{
 			Name:         filepath.Base(path),
 			AbsolutePath: path,
+			IsDir:        odfile.IsDir,
+			ModTime:      odfile.ModTime,
 		}
-
-		if odfile.IsDir {
-			file.IsDir = true
-			file.Childrens = []*File{}
-			temp[path] = file
-		} else {
-			file.IsDir = false
+		if !file.IsDir {
 			file.Size = int64(odfile.Size)
 		}
 
-		cacheKey := formatPath(filepath.Dir(path))
-		if dirFile, ok := temp[cacheKey]; ok {
-			dirFile.Childrens = append(dirFile.Childrens, file)
-		}
-
-		return nil
+		return fn(file)
 	})
-
-	return root, err
-
 }
 
 func (d *OneDriver) PreUploadUrl(path string) (string, error) {
-	return signUrl(fmt.Sprintf("%s/api/v1/driver/onedriver", d.config.Host), d.config.Key, path, time.Hour*2)
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/onedriver", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
 }
 
 func (d *OneDriver) PreDeleteUrl(path string) (string, error) {
-	return signUrl(fmt.Sprintf("%s/api/v1/driver/onedriver", d.config.Host), d.config.Key, path, time.Hour*2)
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/onedriver", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
 }
 
 func (d *OneDriver) DownloadUrl(path string) ([]*DownloadUrl, error) {
 
 	downloadUrls := []*DownloadUrl{}
 
-	downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1/driver/onedriver", d.config.Host), d.config.Key, path, time.Hour*2)
+	downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1%s/driver/onedriver", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
 	if err == nil {
 		downloadUrls = append(downloadUrls, &DownloadUrl{
 			Title:       "OneDriver高速下载线路",
@@ -232,6 +210,7 @@ func (d *OneDriver) Delete(path string) error {
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode == 401 {
 		err = d.refreshToken()
@@ -242,6 +221,11 @@ func (d *OneDriver) Delete(path string) error {
 		}
 	}
 
+	// 文件已经不存在
+	if resp.StatusCode == 404 {
+		return nil
+	}
+
 	if resp.StatusCode != 204 {
 		return errors.New("删除文件失败")
 	}
@@ -249,6 +233,329 @@ func (d *OneDriver) Delete(path string) error {
 	return nil
 }
 
+type ODParentReference struct {
+	ID string `json:"id"`
+}
+
+type ODTransferOption struct {
+	ParentReference ODParentReference `json:"parentReference"`
+	Name            string            `json:"name"`
+}
+
+func (d *OneDriver) Move(src string, dst string) error {
+	return d.transfer("PATCH", src, dst)
+}
+
+// Copy OneDriver的复制是异步完成的，会等待复制完成后再返回
+func (d *OneDriver) Copy(src string, dst string) error {
+	return d.transfer("POST", src, dst)
+}
+
+// copyTimeout 等待OneDriver完成复制的最长时间
+const copyTimeout = 10 * time.Minute
+
+type ODCopyStatus struct {
+	Status    string `json:"status"`
+	ErrorCode string `json:"errorCode"`
+}
+
+// waitCopy 轮询复制返回的进度地址，直到复制完成或者失败
+func (d *OneDriver) waitCopy(monitorUrl string) error {
+
+	if monitorUrl == "" {
+		return errors.New("OneDriver没有返回复制的进度地址")
+	}
+
+	// 进度地址不需要认证，复制完成后会跳转到复制出来的文件
+	client := http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	deadline := time.Now().Add(copyTimeout)
+	for {
+		resp, err := client.Get(monitorUrl)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusSeeOther {
+			resp.Body.Close()
+			return nil
+		}
+
+		status := &ODCopyStatus{}
+		err = json.NewDecoder(resp.Body).Decode(status)
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 || err != nil {
+			return fmt.Errorf("查询复制进度返回错误返回码%d", resp.StatusCode)
+		}
+
+		switch status.Status {
+		case "completed":
+			return nil
+		case "failed":
+			return fmt.Errorf("OneDriver复制文件失败: %s", status.ErrorCode)
+		}
+
+		if time.Now().After(deadline) {
+			return errors.New("等待OneDriver复制文件超时")
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (d *OneDriver) transfer(method string, src string, dst string) error {
+
+	srcPath := filepath.Join(d.config.Path, src)
+	dstPath := filepath.Join(d.config.Path, dst)
+
+	err := d.mkdirAll(filepath.Dir(dstPath))
+	if err != nil {
+		return err
+	}
+
+	parentId, err := d.itemId(filepath.Dir(dstPath))
+	if err != nil {
+		return err
+	}
+
+	transferOption, _ := json.Marshal(&ODTransferOption{
+		ParentReference: ODParentReference{
+			ID: parentId,
+		},
+		Name: filepath.Base(dstPath),
+	})
+
+	transferUrl := fmt.Sprintf("https://graph.microsoft.com/v1.0/me/drive/root:%s", srcPath)
+	if method == "POST" {
+		transferUrl = transferUrl + ":/copy"
+	}
+
+	client := http.Client{}
+
+	request, err := http.NewRequest(method, transferUrl, bytes.NewBuffer(transferOption))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("bearer %s", d.AccessToken))
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		err = d.refreshToken()
+		if err != nil {
+			return err
+		} else {
+			return d.transfer(method, src, dst)
+		}
+	}
+
+	// 只存在于数据库中的空文件夹在OneDriver上没有对应的目录
+	if resp.StatusCode == 404 {
+		return nil
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 202 {
+		return fmt.Errorf("移动或复制文件返回错误返回码%d", resp.StatusCode)
+	}
+
+	if resp.StatusCode == 202 {
+		return d.waitCopy(resp.Header.Get("Location"))
+	}
+
+	return nil
+}
+
+func (d *OneDriver) itemId(dir string) (string, error) {
+
+	itemUrl := fmt.Sprintf("https://graph.microsoft.com/v1.0/me/drive/root:%s?$select=id", dir)
+	if dir == "/" {
+		itemUrl = "https://graph.microsoft.com/v1.0/me/drive/root?$select=id"
+	}
+
+	client := http.Client{}
+
+	request, err := http.NewRequest("GET", itemUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("bearer %s", d.AccessToken))
+	resp, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		err = d.refreshToken()
+		if err != nil {
+			return "", err
+		} else {
+			return d.itemId(dir)
+		}
+	}
+
+	if resp.StatusCode != 200 {
+		return "", errors.New("获取目录信息失败")
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	itemResp := Json{}
+	err = json.Unmarshal(data, &itemResp)
+	if err != nil {
+		return "", err
+	}
+
+	id, _ := itemResp["id"].(string)
+	return id, nil
+}
+
+type ODItem struct {
+	Name                 string    `json:"name"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	Folder               *Json     `json:"folder"`
+	File                 *struct {
+		Hashes map[string]string `json:"hashes"`
+	} `json:"file"`
+}
+
+// Stat 通过Graph接口获取文件的信息，个人版的OneDrive提供sha1Hash，商业版提供quickXorHash
+func (d *OneDriver) Stat(key string) (*File, error) {
+
+	key = formatKey(key)
+	filePath := filepath.Join(d.config.Path, key)
+
+	client := http.Client{}
+
+	request, err := http.NewRequest("GET", fmt.Sprintf("https://graph.microsoft.com/v1.0/me/drive/root:%s?$select=name,size,lastModifiedDateTime,folder,file", filePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("bearer %s", d.AccessToken))
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		err = d.refreshToken()
+		if err != nil {
+			return nil, err
+		} else {
+			return d.Stat(key)
+		}
+	}
+
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("文件%s不存在: %w", key, fs.ErrNotExist)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New("获取文件信息失败")
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &ODItem{}
+	err = json.Unmarshal(data, item)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{
+		Name:         path.Base(key),
+		AbsolutePath: key,
+		IsDir:        item.Folder != nil,
+		ModTime:      item.LastModifiedDateTime,
+	}
+	if !file.IsDir {
+		file.Size = item.Size
+	}
+	if item.File != nil {
+		file.Hash = item.File.Hashes["sha1Hash"]
+		if file.Hash == "" {
+			file.Hash = item.File.Hashes["quickXorHash"]
+		}
+	}
+
+	return file, nil
+}
+
+type ODCreateFolderOption struct {
+	Name             string               `json:"name"`
+	Folder           Json                 `json:"folder"`
+	ConflictBehavior ConflictBehaviorType `json:"@microsoft.graph.conflictBehavior"`
+}
+
+// mkdirAll 逐级创建目录，已经存在的目录会被忽略
+func (d *OneDriver) mkdirAll(dir string) error {
+
+	if dir == "/" || dir == "." {
+		return nil
+	}
+
+	err := d.mkdirAll(filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+
+	createFolderOption, _ := json.Marshal(&ODCreateFolderOption{
+		Name:             filepath.Base(dir),
+		Folder:           Json{},
+		ConflictBehavior: Fail,
+	})
+
+	createUrl := fmt.Sprintf("https://graph.microsoft.com/v1.0/me/drive/root:%s:/children", filepath.Dir(dir))
+	if filepath.Dir(dir) == "/" {
+		createUrl = "https://graph.microsoft.com/v1.0/me/drive/root/children"
+	}
+
+	client := http.Client{}
+
+	request, err := http.NewRequest("POST", createUrl, bytes.NewBuffer(createFolderOption))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", fmt.Sprintf("bearer %s", d.AccessToken))
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 401 {
+		err = d.refreshToken()
+		if err != nil {
+			return err
+		} else {
+			return d.mkdirAll(dir)
+		}
+	}
+
+	// 409表示目录已经存在
+	if resp.StatusCode != 201 && resp.StatusCode != 409 {
+		return fmt.Errorf("创建目录返回错误返回码%d", resp.StatusCode)
+	}
+
+	return nil
+}
+
 type OnedriveErrorResp struct {
 	Error Json `json:"error"`
 }
@@ -296,6 +603,15 @@ func (d *OneDriver) Link(path string) (string, error) {
 	return downloadResp["@microsoft.graph.downloadUrl"].(string), nil
 }
 
+func (d *OneDriver) Put(key string, body io.Reader, size int64) error {
+	return d.Upload(UploaderFileStream{
+		Name:       path.Base(key),
+		parentPath: path.Dir(key),
+		DataLength: int(size),
+		reader:     ioutil.NopCloser(body),
+	})
+}
+
 func (d *OneDriver) Upload(file UploaderFileStream) error {
 	if file.DataLength <= 1024*1024*3 {
 		return d.uploadFile(file, true)
@@ -476,22 +792,59 @@ func (d *OneDriver) uploadFile(file UploaderFileStream, forceWrite bool) error {
 }
 
 type ODListFileResp struct {
-	Value []*Json `json:""`
+	Value    []*Json `json:""`
+	NextLink string  `json:"@odata.nextLink"`
 }
 
 type ODFile struct {
-	Name  string
-	Size  int
-	IsDir bool
+	Name    string
+	Size    int
+	IsDir   bool
+	ModTime time.Time
 }
 
 func (d *OneDriver) listDir(path string) ([]*ODFile, error) {
 
 	filePath := filepath.Join(d.config.Path, path)
 
+	odfiles := []*ODFile{}
+
+	// 目录中的文件较多时需要分页获取
+	nextLink := fmt.Sprintf("https://graph.microsoft.com/v1.0/me/drive/items/root:%s:/children", filePath)
+	for nextLink != "" {
+		fileResp, err := d.listPage(nextLink)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, json := range fileResp.Value {
+			odfile := &ODFile{
+				Name: (*json)["name"].(string),
+			}
+			if _, ok := (*json)["folder"]; ok {
+				odfile.IsDir = true
+			} else {
+				odfile.Size = int((*json)["size"].(float64))
+				odfile.IsDir = false
+			}
+			if modTime, ok := (*json)["lastModifiedDateTime"].(string); ok {
+				odfile.ModTime, _ = time.Parse(time.RFC3339, modTime)
+			}
+
+			odfiles = append(odfiles, odfile)
+		}
+
+		nextLink = fileResp.NextLink
+	}
+
+	return odfiles, nil
+}
+
+func (d *OneDriver) listPage(pageUrl string) (*ODListFileResp, error) {
+
 	client := http.Client{}
 
-	request, err := http.NewRequest("GET", fmt.Sprintf("https://graph.microsoft.com/v1.0/me/drive/items/root:%s:/children", filePath), nil)
+	request, err := http.NewRequest("GET", pageUrl, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -500,48 +853,37 @@ func (d *OneDriver) listDir(path string) ([]*ODFile, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode == 401 {
 		err = d.refreshToken()
 		if err != nil {
 			return nil, err
 		} else {
-			return d.listDir(path)
+			return d.listPage(pageUrl)
 		}
 	}
 
+	if resp.StatusCode == 404 {
+		return nil, fmt.Errorf("目录不存在: %w", fs.ErrNotExist)
+	}
+
 	if resp.StatusCode != 200 {
 		return nil, errors.New("获取目录列表失败")
 	}
 
-	defer resp.Body.Close()
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
 
-	fileResp := ODListFileResp{}
-	err = json.Unmarshal(data, &fileResp)
+	fileResp := &ODListFileResp{}
+	err = json.Unmarshal(data, fileResp)
 	if err != nil {
-		return nil, nil
-	}
-
-	odfiles := []*ODFile{}
-	for _, json := range fileResp.Value {
-		odfile := &ODFile{
-			Name: (*json)["name"].(string),
-		}
-		if _, ok := (*json)["folder"]; ok {
-			odfile.IsDir = true
-		} else {
-			odfile.Size = int((*json)["size"].(float64))
-			odfile.IsDir = false
-		}
-
-		odfiles = append(odfiles, odfile)
+		return nil, err
 	}
 
-	return odfiles, nil
+	return fileResp, nil
 }
 
 type OnedriverTokenResp struct {
@@ -2,13 +2,17 @@ package driver
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io/fs"
 	"net/http"
+	"net/url"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -38,6 +42,11 @@ type S3Driver struct {
 	s3     *s3.S3
 	key    string
 	host   string
+	prefix string
+}
+
+func (d *S3Driver) setRoutePrefix(prefix string) {
+	d.prefix = prefix
 }
 
 func (d *S3Driver) Check() error {
@@ -89,7 +98,7 @@ func (d *S3Driver) initConfig(config interface{}) error {
 
 func (d *S3Driver) InitDriver(e *echo.Group, db *gorm.DB) error {
 
-	e.GET("/driver/s3", func(ctx echo.Context) error {
+	e.GET(d.prefix+"/driver/s3", func(ctx echo.Context) error {
 
 		filepath := utils.GetValue(ctx, "path")
 
@@ -112,76 +121,92 @@ func (d *S3Driver) InitDriver(e *echo.Group, db *gorm.DB) error {
 	return nil
 }
 
-func (d *S3Driver) WalkDir(prefix string) (*File, error) {
+func (d *S3Driver) WalkDir(key string) (*File, error) {
+	return walkDir(d.Walk, key)
+}
 
-	prefix = strings.TrimLeft(prefix, "/")
-	if prefix != "" && !strings.HasSuffix(prefix, "/") {
-		prefix = prefix + "/"
-	}
+// Walk 对象存储中没有真正的文件夹，文件夹根据对象的路径推断出来。对象按照字典序返回，
+// 同一个文件夹下的对象总是连续的，所以只需要记住上一个对象所在的文件夹就可以保证文件夹只返回一次
+func (d *S3Driver) Walk(key string, fn WalkFunc) error {
 
-	root := File{
-		Childrens:    []*File{},
-		AbsolutePath: fmt.Sprintf("/%s", prefix),
-		Name:         path.Base(prefix),
-		IsDir:        true,
-	}
+	key = formatKey(key)
 
-	if root.Name == "." {
-		root.Name = "/"
+	prefix := strings.TrimLeft(key, "/")
+	if prefix != "" {
+		prefix = prefix + "/"
 	}
 
+	dirs := []string{}
+	var walkErr error
+
 	err := d.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
 		Bucket: aws.String(d.Bucket),
 		Prefix: aws.String(prefix),
 	}, func(loo *s3.ListObjectsV2Output, b bool) bool {
 		for _, obj := range loo.Contents {
-			key := *(obj.Key)
-			fmt.Println("path-->", key)
-			cur := &root
-			key = strings.TrimPrefix(key, prefix)
-			names := strings.Split(key, "/")
-			isDir := false
-			if *obj.Size == 0 {
-				isDir = true
+			name := strings.TrimPrefix(*obj.Key, prefix)
+			isDir := strings.HasSuffix(name, "/")
+
+			names := []string{}
+			for _, n := range strings.Split(name, "/") {
+				if n != "" {
+					names = append(names, n)
+				}
 			}
-			for _, name := range names {
-				if name == "" {
-					continue
+			if len(names) == 0 {
+				continue
+			}
+
+			parents := names
+			if !isDir {
+				parents = names[:len(names)-1]
+			}
+
+			same := 0
+			for same < len(dirs) && same < len(parents) && dirs[same] == parents[same] {
+				same++
+			}
+			dirs = dirs[:same]
+
+			for _, dirName := range parents[same:] {
+				dirs = append(dirs, dirName)
+				file := &File{
+					Name:         dirName,
+					IsDir:        true,
+					AbsolutePath: path.Join(key, strings.Join(dirs, "/")),
 				}
-				find := false
-				for _, cfile := range cur.Childrens {
-					if cfile.Name == name {
-						cur = cfile
-						find = true
-						break
-					}
+				if isDir && len(dirs) == len(parents) {
+					file.ModTime = aws.TimeValue(obj.LastModified)
 				}
-
-				if !find {
-					cur.IsDir = true
-					cur.Size = 0
-					newfile := &File{
-						Name:         name,
-						IsDir:        isDir,
-						Size:         *obj.Size,
-						AbsolutePath: path.Join(cur.AbsolutePath, name),
-					}
-					cur.Childrens = append(cur.Childrens, newfile)
-					cur = newfile
+				if walkErr = fn(file); walkErr != nil {
+					return false
 				}
+			}
 
+			if !isDir {
+				file := &File{
+					Name:         names[len(names)-1],
+					Size:         *obj.Size,
+					AbsolutePath: path.Join(key, strings.Join(names, "/")),
+					ModTime:      aws.TimeValue(obj.LastModified),
+				}
+				if walkErr = fn(file); walkErr != nil {
+					return false
+				}
 			}
 		}
 
-		return b
+		// 返回false会停止获取下一页
+		return true
 	})
 
-	if err != nil {
-		return nil, err
+	if walkErr != nil {
+		return walkErr
 	}
 
-	return &root, nil
+	return err
 }
+
 func (d *S3Driver) PreUploadUrl(key string) (string, error) {
 
 	req, _ := d.s3.PutObjectRequest(&s3.PutObjectInput{
@@ -202,11 +227,48 @@ func (d *S3Driver) PreDeleteUrl(key string) (string, error) {
 	return req.Presign(15 * time.Minute)
 }
 
+func (d *S3Driver) Delete(key string) error {
+
+	_, err := d.s3.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+// Stat 只能获取文件的信息，对象存储中没有文件夹
+func (d *S3Driver) Stat(key string) (*File, error) {
+
+	key = formatKey(key)
+
+	output, err := d.s3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(strings.TrimLeft(key, "/")),
+	})
+
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return nil, fmt.Errorf("文件%s不存在: %w", key, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &File{
+		Name:         path.Base(key),
+		AbsolutePath: key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ModTime:      aws.TimeValue(output.LastModified),
+		Hash:         strings.Trim(aws.StringValue(output.ETag), `"`),
+	}, nil
+}
+
 func (d *S3Driver) DownloadUrl(path string) ([]*DownloadUrl, error) {
 
 	var downloads []*DownloadUrl = []*DownloadUrl{}
 
-	downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1/driver/s3", d.host), d.key, path, time.Minute*10)
+	downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1%s/driver/s3", d.host, d.prefix), d.key, path, time.Minute*10)
 	if err == nil {
 		downloads = append(downloads, &DownloadUrl{
 			Title:       "下载地址",
@@ -216,3 +278,205 @@ func (d *S3Driver) DownloadUrl(path string) ([]*DownloadUrl, error) {
 
 	return downloads, nil
 }
+
+type s3Object struct {
+	Key  string
+	Size int64
+}
+
+// listObjects 对象存储中没有文件夹的概念，文件夹需要通过前缀列出其下所有的对象
+func (d *S3Driver) listObjects(key string) ([]*s3Object, error) {
+
+	objects := []*s3Object{}
+	err := d.s3.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(d.Bucket),
+		Prefix: aws.String(key),
+	}, func(loo *s3.ListObjectsV2Output, b bool) bool {
+		for _, obj := range loo.Contents {
+			if *obj.Key == key || strings.HasPrefix(*obj.Key, key+"/") {
+				objects = append(objects, &s3Object{
+					Key:  *obj.Key,
+					Size: *obj.Size,
+				})
+			}
+		}
+		return true
+	})
+
+	return objects, err
+}
+
+func (d *S3Driver) Copy(src string, dst string) error {
+
+	_, err := d.copyObjects(src, dst)
+	return err
+}
+
+// Move 先复制所有的对象再删除原来的对象，中途失败时已经删除的对象都已经复制完成，
+// 再次移动时只会处理原路径下剩下的对象
+func (d *S3Driver) Move(src string, dst string) error {
+
+	objects, err := d.copyObjects(src, dst)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		_, err = d.s3.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(d.Bucket),
+			Key:    aws.String(object.Key),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *S3Driver) copyObjects(src string, dst string) ([]*s3Object, error) {
+
+	src = strings.Trim(src, "/")
+	dst = strings.Trim(dst, "/")
+
+	objects, err := d.listObjects(src)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, object := range objects {
+		err = d.copyObject(object, dst+strings.TrimPrefix(object.Key, src))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return objects, nil
+}
+
+func (d *S3Driver) CreateMultipartUpload(key string) (string, error) {
+
+	upload, err := d.s3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(strings.TrimLeft(key, "/")),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(upload.UploadId), nil
+}
+
+func (d *S3Driver) PreUploadPartUrl(key string, uploadId string, partNumber int) (string, error) {
+
+	req, _ := d.s3.UploadPartRequest(&s3.UploadPartInput{
+		Bucket:     aws.String(d.Bucket),
+		Key:        aws.String(strings.TrimLeft(key, "/")),
+		UploadId:   aws.String(uploadId),
+		PartNumber: aws.Int64(int64(partNumber)),
+	})
+
+	return req.Presign(2 * time.Hour)
+}
+
+func (d *S3Driver) CompleteMultipartUpload(key string, uploadId string, parts []*UploadPart) error {
+
+	completedParts := []*s3.CompletedPart{}
+	for _, part := range parts {
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(int64(part.PartNumber)),
+		})
+	}
+
+	_, err := d.s3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(d.Bucket),
+		Key:             aws.String(strings.TrimLeft(key, "/")),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completedParts},
+	})
+
+	return err
+}
+
+// AbortMultipartUpload 分片上传已经不存在时不返回错误
+func (d *S3Driver) AbortMultipartUpload(key string, uploadId string) error {
+
+	_, err := d.s3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(d.Bucket),
+		Key:      aws.String(strings.TrimLeft(key, "/")),
+		UploadId: aws.String(uploadId),
+	})
+
+	var aerr awserr.Error
+	if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchUpload {
+		return nil
+	}
+
+	return err
+}
+
+// 单次复制最大只支持5G，超过的需要使用分片复制
+const maxCopySize int64 = 5 * 1024 * 1024 * 1024
+const copyPartSize int64 = 1024 * 1024 * 1024
+
+func (d *S3Driver) copyObject(object *s3Object, dstKey string) error {
+
+	copySource := url.PathEscape(fmt.Sprintf("%s/%s", d.Bucket, object.Key))
+
+	if object.Size <= maxCopySize {
+		_, err := d.s3.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(d.Bucket),
+			CopySource: aws.String(copySource),
+			Key:        aws.String(dstKey),
+		})
+		return err
+	}
+
+	upload, err := d.s3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(d.Bucket),
+		Key:    aws.String(dstKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	parts := []*s3.CompletedPart{}
+	for start, partNumber := int64(0), int64(1); start < object.Size; start, partNumber = start+copyPartSize, partNumber+1 {
+		end := start + copyPartSize - 1
+		if end > object.Size-1 {
+			end = object.Size - 1
+		}
+
+		part, err := d.s3.UploadPartCopy(&s3.UploadPartCopyInput{
+			Bucket:          aws.String(d.Bucket),
+			Key:             aws.String(dstKey),
+			UploadId:        upload.UploadId,
+			PartNumber:      aws.Int64(partNumber),
+			CopySource:      aws.String(copySource),
+			CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+		})
+		if err != nil {
+			d.s3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(d.Bucket),
+				Key:      aws.String(dstKey),
+				UploadId: upload.UploadId,
+			})
+			return err
+		}
+
+		parts = append(parts, &s3.CompletedPart{
+			ETag:       part.CopyPartResult.ETag,
+			PartNumber: aws.Int64(partNumber),
+		})
+	}
+
+	_, err = d.s3.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(d.Bucket),
+		Key:             aws.String(dstKey),
+		UploadId:        upload.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+
+	return err
+}
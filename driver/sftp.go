@@ -0,0 +1,492 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/utils"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"gorm.io/gorm"
+)
+
+func init() {
+	RegsiterDriver("sftp", "SFTP", &SftpDriver{}, &SftpDriverConfig{})
+}
+
+type SftpDriverConfig struct {
+	Address    string `arg:"address;服务器地址;SFTP服务器地址，例如192.168.1.2:22;required" json:"address"`
+	Username   string `arg:"username;用户名;SSH登录用户名;required" json:"username"`
+	Password   string `arg:"password;密码;SSH登录密码，与私钥至少填写一个" json:"password"`
+	PrivateKey string `arg:"privateKey;私钥;PEM格式的SSH私钥，与密码至少填写一个" json:"privateKey"`
+	Passphrase string `arg:"passphrase;私钥密码;私钥的保护密码，没有可不填" json:"passphrase"`
+	HostKey    string `arg:"hostKey;主机公钥;authorized_keys格式的服务器公钥，不填写则不校验服务器身份" json:"hostKey"`
+	Path       string `arg:"path;目录;要作为列表的服务器目录;required" json:"path"`
+	Key        string `arg:"key;签名key;部分接口所需要使用的签名key,随意填写;required" json:"key"`
+	Host       string `arg:"host;服务地址;NextList服务地址,需要外网能够访问;required" json:"host"`
+}
+
+type SftpDriver struct {
+	config SftpDriverConfig
+	lock   sync.Mutex
+	conn   *ssh.Client
+	client *sftp.Client
+	prefix string
+}
+
+func (d *SftpDriver) setRoutePrefix(prefix string) {
+	d.prefix = prefix
+}
+
+func (d *SftpDriver) initConfig(config interface{}) error {
+
+	sftpConfig := config.(*SftpDriverConfig)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.closeClient()
+	d.config = *sftpConfig
+
+	if d.config.Path == "" {
+		d.config.Path = "/"
+	}
+
+	return nil
+}
+
+func (d *SftpDriver) Check() error {
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	err = client.MkdirAll(d.config.Path)
+	if err != nil {
+		return err
+	}
+
+	tempPath := path.Join(d.config.Path, "test_temp")
+	file, err := client.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write([]byte("test!!!"))
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Remove(tempPath)
+}
+
+func (d *SftpDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
+
+	e.PUT(d.prefix+"/driver/sftp", func(ctx echo.Context) error {
+
+		filepath := utils.GetValue(ctx, "path")
+
+		body := ctx.Request().Body
+		defer body.Close()
+
+		err := d.Put(filepath, body, ctx.Request().ContentLength)
+		if err != nil {
+			return err
+		}
+
+		ctx.Response().Status = http.StatusCreated
+
+		return nil
+	}, checkSignHandler(d.config.Key))
+
+	e.DELETE(d.prefix+"/driver/sftp", func(ctx echo.Context) error {
+
+		filepath := utils.GetValue(ctx, "path")
+		return d.Delete(filepath)
+
+	}, checkSignHandler(d.config.Key))
+
+	e.GET(d.prefix+"/driver/sftp", func(ctx echo.Context) error {
+
+		filepath := utils.GetValue(ctx, "path")
+
+		client, err := d.getClient()
+		if err != nil {
+			return err
+		}
+
+		file, err := client.Open(path.Join(d.config.Path, filepath))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		name := path.Base(filepath)
+		header := ctx.Response().Header()
+		header.Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", name))
+		if mtype := utils.FindMimetypeByExt(path.Ext(name)); mtype != "" {
+			header.Set("Content-Type", mtype)
+		}
+
+		http.ServeContent(ctx.Response(), ctx.Request(), name, info.ModTime(), file)
+
+		return nil
+	}, checkSignHandler(d.config.Key))
+
+	return nil
+}
+
+func (d *SftpDriver) WalkDir(key string) (*File, error) {
+	return walkDir(d.Walk, key)
+}
+
+func (d *SftpDriver) Walk(key string, fn WalkFunc) error {
+
+	key = formatKey(key)
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	formatPath := func(p string) string {
+		p = strings.TrimPrefix(p, strings.TrimRight(d.config.Path, "/"))
+		p = strings.TrimRight(p, "/")
+		if p == "" || p == "." {
+			return "/"
+		}
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		return p
+	}
+
+	walker := client.Walk(path.Join(d.config.Path, key))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		filePath := formatPath(walker.Path())
+		if filePath == key {
+			continue
+		}
+
+		info := walker.Stat()
+		file := &File{
+			Name:         path.Base(filePath),
+			AbsolutePath: filePath,
+			IsDir:        info.IsDir(),
+			ModTime:      info.ModTime(),
+		}
+		if !file.IsDir {
+			file.Size = info.Size()
+		}
+
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *SftpDriver) PreUploadUrl(path string) (string, error) {
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/sftp", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
+}
+
+func (d *SftpDriver) PreDeleteUrl(path string) (string, error) {
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/sftp", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
+}
+
+func (d *SftpDriver) DownloadUrl(path string) ([]*DownloadUrl, error) {
+
+	downloadUrls := []*DownloadUrl{}
+
+	downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1%s/driver/sftp", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
+	if err == nil {
+		downloadUrls = append(downloadUrls, &DownloadUrl{
+			Title:       "下载链接",
+			DownloadUrl: downloadUrl,
+		})
+	}
+
+	return downloadUrls, nil
+}
+
+func (d *SftpDriver) Stat(key string) (*File, error) {
+
+	key = formatKey(key)
+
+	client, err := d.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := client.Stat(path.Join(d.config.Path, key))
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{
+		Name:         path.Base(key),
+		AbsolutePath: key,
+		IsDir:        info.IsDir(),
+		ModTime:      info.ModTime(),
+	}
+	if !file.IsDir {
+		file.Size = info.Size()
+	}
+
+	return file, nil
+}
+
+func (d *SftpDriver) Put(key string, body io.Reader, size int64) error {
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	absPath := path.Join(d.config.Path, key)
+	err = client.MkdirAll(path.Dir(absPath))
+	if err != nil {
+		return err
+	}
+
+	dstFile, err := client.Create(absPath)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	_, err = dstFile.ReadFrom(body)
+	return err
+}
+
+func (d *SftpDriver) Delete(key string) error {
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	err = client.Remove(path.Join(d.config.Path, key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// RemoveDir 只删除空文件夹，文件夹中还有不在数据库中的文件时会被保留
+func (d *SftpDriver) RemoveDir(key string) error {
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	dirPath := path.Join(d.config.Path, key)
+
+	entries, err := client.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if len(entries) > 0 {
+		return nil
+	}
+
+	err = client.RemoveDirectory(dirPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// getClient 返回一个可用的sftp连接，连接断开后会自动重新连接
+func (d *SftpDriver) getClient() (*sftp.Client, error) {
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.client != nil {
+		if _, err := d.client.Getwd(); err == nil {
+			return d.client, nil
+		}
+		d.closeClient()
+	}
+
+	sshConfig, err := d.sshConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := ssh.Dial("tcp", d.config.Address, sshConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	d.conn = conn
+	d.client = client
+
+	return client, nil
+}
+
+func (d *SftpDriver) closeClient() {
+	if d.client != nil {
+		d.client.Close()
+		d.client = nil
+	}
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+}
+
+func (d *SftpDriver) sshConfig() (*ssh.ClientConfig, error) {
+
+	auths := []ssh.AuthMethod{}
+
+	if d.config.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if d.config.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(d.config.PrivateKey), []byte(d.config.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(d.config.PrivateKey))
+		}
+		if err != nil {
+			return nil, err
+		}
+		auths = append(auths, ssh.PublicKeys(signer))
+	}
+
+	if d.config.Password != "" {
+		auths = append(auths, ssh.Password(d.config.Password))
+	}
+
+	if len(auths) == 0 {
+		return nil, errors.New("密码和私钥至少需要填写一个")
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if d.config.HostKey != "" {
+		hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(d.config.HostKey))
+		if err != nil {
+			return nil, err
+		}
+		hostKeyCallback = ssh.FixedHostKey(hostKey)
+	}
+
+	return &ssh.ClientConfig{
+		User:            d.config.Username,
+		Auth:            auths,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	}, nil
+}
+
+func (d *SftpDriver) Move(src string, dst string) error {
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	srcPath := path.Join(d.config.Path, src)
+	dstPath := path.Join(d.config.Path, dst)
+
+	if _, err := client.Stat(srcPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	err = client.MkdirAll(path.Dir(dstPath))
+	if err != nil {
+		return err
+	}
+
+	return client.Rename(srcPath, dstPath)
+}
+
+func (d *SftpDriver) Copy(src string, dst string) error {
+
+	client, err := d.getClient()
+	if err != nil {
+		return err
+	}
+
+	srcPath := path.Join(d.config.Path, src)
+	dstPath := path.Join(d.config.Path, dst)
+
+	if _, err := client.Stat(srcPath); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	walker := client.Walk(srcPath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+
+		target := dstPath + strings.TrimPrefix(walker.Path(), srcPath)
+		if walker.Stat().IsDir() {
+			err = client.MkdirAll(target)
+		} else {
+			err = d.copyFile(client, walker.Path(), target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *SftpDriver) copyFile(client *sftp.Client, src string, dst string) error {
+
+	err := client.MkdirAll(path.Dir(dst))
+	if err != nil {
+		return err
+	}
+
+	srcFile, err := client.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := client.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	_, err = dstFile.ReadFrom(srcFile)
+	return err
+}
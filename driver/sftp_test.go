@@ -0,0 +1,256 @@
+package driver
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestSftpServer 启动一个只接受密码登录的进程内SFTP服务
+func startTestSftpServer(t *testing.T, password string) (string, ssh.PublicKey) {
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConfig := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSftpConn(conn, serverConfig)
+		}
+	}()
+
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+func serveTestSftpConn(conn net.Conn, serverConfig *ssh.ServerConfig) {
+
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			return
+		}
+		go func() {
+			server.Serve()
+			server.Close()
+		}()
+	}
+}
+
+func newTestSftpDriver(t *testing.T) (*SftpDriver, string) {
+
+	addr, hostKey := startTestSftpServer(t, "nextlist")
+	root := t.TempDir()
+
+	sdriver := &SftpDriver{}
+	sdriver.initConfig(&SftpDriverConfig{
+		Address:  addr,
+		Username: "nextlist",
+		Password: "nextlist",
+		HostKey:  string(ssh.MarshalAuthorizedKey(hostKey)),
+		Path:     root,
+		Key:      "key",
+		Host:     "http://localhost:8081",
+	})
+	t.Cleanup(func() {
+		sdriver.lock.Lock()
+		sdriver.closeClient()
+		sdriver.lock.Unlock()
+	})
+
+	return sdriver, root
+}
+
+func Test_SftpCheck(t *testing.T) {
+
+	sdriver, _ := newTestSftpDriver(t)
+
+	if err := sdriver.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	sdriver.config.Password = "wrong"
+	sdriver.closeClient()
+	if err := sdriver.Check(); err == nil {
+		t.Fatal("expect auth error")
+	}
+}
+
+func Test_SftpWalkDir(t *testing.T) {
+
+	sdriver, root := newTestSftpDriver(t)
+
+	for key, content := range map[string]string{
+		"/a.txt":      "hello",
+		"/docs/b.txt": "hello world",
+		"/docs/sub/c": "c",
+	} {
+		if err := sdriver.Put(key, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "docs", "b.txt"))
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("unexpected content %s %v", data, err)
+	}
+
+	dir, err := sdriver.WalkDir("/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dir.AbsolutePath != "/docs" || len(dir.Childrens) != 2 {
+		t.Fatalf("unexpected dir %+v", dir)
+	}
+
+	for _, child := range dir.Childrens {
+		switch child.AbsolutePath {
+		case "/docs/b.txt":
+			if child.IsDir || child.Size != 11 {
+				t.Fatalf("unexpected file %+v", child)
+			}
+		case "/docs/sub":
+			if !child.IsDir || len(child.Childrens) != 1 || child.Childrens[0].AbsolutePath != "/docs/sub/c" {
+				t.Fatalf("unexpected dir %+v", child)
+			}
+		default:
+			t.Fatalf("unexpected entry %+v", child)
+		}
+	}
+
+	if err := sdriver.Delete("/docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs", "b.txt")); !os.IsNotExist(err) {
+		t.Fatal("file should be deleted")
+	}
+}
+
+func Test_SftpDownload(t *testing.T) {
+
+	sdriver, _ := newTestSftpDriver(t)
+
+	if err := sdriver.Put("/video.mp4", strings.NewReader("0123456789"), 10); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	sdriver.InitDriver(e.Group("/api/v1"), nil)
+
+	signed, err := signUrl("/api/v1/driver/sftp", sdriver.config.Key, "/video.mp4", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, signed, nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "234" {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func Test_SftpMove(t *testing.T) {
+
+	sdriver, root := newTestSftpDriver(t)
+
+	if err := sdriver.Put("/docs/a.txt", strings.NewReader("a"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sdriver.Move("/docs/a.txt", "/archive/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sdriver.Move("/empty", "/archive/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "archive", "b.txt"))
+	if err != nil || string(data) != "a" {
+		t.Fatalf("unexpected content %s %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "docs", "a.txt")); !os.IsNotExist(err) {
+		t.Fatal("file should be moved")
+	}
+}
+
+func Test_SftpCopy(t *testing.T) {
+
+	sdriver, root := newTestSftpDriver(t)
+
+	if err := sdriver.Put("/docs/sub/a.txt", strings.NewReader("a"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sdriver.Copy("/docs", "/backup/docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"docs", "backup/docs"} {
+		data, err := ioutil.ReadFile(filepath.Join(root, dir, "sub", "a.txt"))
+		if err != nil || string(data) != "a" {
+			t.Fatalf("unexpected content %s %v", data, err)
+		}
+	}
+}
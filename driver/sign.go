@@ -3,6 +3,7 @@ package driver
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -57,7 +58,7 @@ func checkSignHandler(key string) echo.MiddlewareFunc {
 
 const timeLayout string = "2006-01-02 15:04:05 "
 
-func signUrl(url string, key string, path string, expireDuration time.Duration) (string, error) {
+func signUrl(baseUrl string, key string, path string, expireDuration time.Duration) (string, error) {
 
 	expireTime := time.Now().Add(expireDuration)
 	expireTimeStr := expireTime.Format(timeLayout)
@@ -69,6 +70,6 @@ func signUrl(url string, key string, path string, expireDuration time.Duration)
 		return "", err
 	}
 
-	return fmt.Sprintf("%s?path=%s&expireTime=%s&sign=%s", url, path, expireTimeStr, sign), nil
+	return fmt.Sprintf("%s?path=%s&expireTime=%s&sign=%s", baseUrl, url.QueryEscape(path), url.QueryEscape(expireTimeStr), sign), nil
 
 }
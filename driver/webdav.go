@@ -0,0 +1,589 @@
+package driver
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/utils"
+	"gorm.io/gorm"
+)
+
+func init() {
+	RegsiterDriver("webdav", "WebDAV", &WebDavDriver{}, &WebDavDriverConfig{})
+}
+
+type WebDavDriverConfig struct {
+	Url      string `arg:"url;WebDAV地址;WebDAV服务的地址，例如https://nas.example.com/dav;required" json:"url"`
+	Username string `arg:"username;用户名;WebDAV服务的用户名" json:"username"`
+	Password string `arg:"password;密码;WebDAV服务的密码" json:"password"`
+	Path     string `arg:"path;目录;要作为列表的WebDAV目录;required" json:"path"`
+	Key      string `arg:"key;签名key;部分接口所需要使用的签名key,随意填写;required" json:"key"`
+	Host     string `arg:"host;服务地址;NextList服务地址,需要外网能够访问;required" json:"host"`
+}
+
+type WebDavDriver struct {
+	config WebDavDriverConfig
+	client *http.Client
+	prefix string
+}
+
+func (d *WebDavDriver) setRoutePrefix(prefix string) {
+	d.prefix = prefix
+}
+
+func (d *WebDavDriver) initConfig(config interface{}) error {
+
+	webdavConfig := config.(*WebDavDriverConfig)
+	d.config = *webdavConfig
+	d.config.Url = strings.TrimRight(d.config.Url, "/")
+
+	if d.config.Path == "" {
+		d.config.Path = "/"
+	}
+
+	d.client = &http.Client{}
+
+	return nil
+}
+
+func (d *WebDavDriver) Check() error {
+
+	key := fmt.Sprintf("/test_temp_%d", time.Now().Unix())
+	err := d.Put(key, strings.NewReader("test!!!"), 7)
+	if err != nil {
+		return err
+	}
+
+	return d.Delete(key)
+}
+
+func (d *WebDavDriver) InitDriver(e *echo.Group, db *gorm.DB) error {
+
+	e.PUT(d.prefix+"/driver/webdav", func(ctx echo.Context) error {
+
+		filepath := utils.GetValue(ctx, "path")
+
+		body := ctx.Request().Body
+		defer body.Close()
+
+		err := d.Put(filepath, body, ctx.Request().ContentLength)
+		if err != nil {
+			return err
+		}
+
+		ctx.Response().Status = http.StatusCreated
+
+		return nil
+	}, checkSignHandler(d.config.Key))
+
+	e.DELETE(d.prefix+"/driver/webdav", func(ctx echo.Context) error {
+
+		filepath := utils.GetValue(ctx, "path")
+		return d.Delete(filepath)
+
+	}, checkSignHandler(d.config.Key))
+
+	e.GET(d.prefix+"/driver/webdav", func(ctx echo.Context) error {
+
+		filepath := utils.GetValue(ctx, "path")
+
+		resp, err := d.Get(filepath, ctx.Request().Header.Get("Range"))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		header := ctx.Response().Header()
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified", "ETag"} {
+			if value := resp.Header.Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		header.Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", path.Base(filepath)))
+
+		ctx.Response().WriteHeader(resp.StatusCode)
+		_, err = io.Copy(ctx.Response(), resp.Body)
+		return err
+
+	}, checkSignHandler(d.config.Key))
+
+	return nil
+}
+
+func (d *WebDavDriver) WalkDir(key string) (*File, error) {
+	return walkDir(d.Walk, key)
+}
+
+func (d *WebDavDriver) Walk(key string, fn WalkFunc) error {
+
+	key = formatKey(key)
+
+	entries, err := d.listDir(key)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		file := &File{
+			Name:         entry.Name,
+			IsDir:        entry.IsDir,
+			Size:         entry.Size,
+			AbsolutePath: path.Join(key, entry.Name),
+			ModTime:      entry.ModTime,
+		}
+		if err := fn(file); err != nil {
+			return err
+		}
+		if file.IsDir {
+			if err := d.Walk(file.AbsolutePath, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (d *WebDavDriver) PreUploadUrl(path string) (string, error) {
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/webdav", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
+}
+
+func (d *WebDavDriver) PreDeleteUrl(path string) (string, error) {
+	return signUrl(fmt.Sprintf("%s/api/v1%s/driver/webdav", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
+}
+
+func (d *WebDavDriver) DownloadUrl(path string) ([]*DownloadUrl, error) {
+
+	downloadUrls := []*DownloadUrl{}
+
+	downloadUrl, err := signUrl(fmt.Sprintf("%s/api/v1%s/driver/webdav", d.config.Host, d.prefix), d.config.Key, path, time.Hour*2)
+	if err == nil {
+		downloadUrls = append(downloadUrls, &DownloadUrl{
+			Title:       "下载链接",
+			DownloadUrl: downloadUrl,
+		})
+	}
+
+	return downloadUrls, nil
+}
+
+// davUrl 将WebDAV服务上的路径转换为完整的访问地址
+func (d *WebDavDriver) davUrl(remotePath string, isDir bool) (string, error) {
+
+	u, err := url.Parse(d.config.Url)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = path.Join(u.Path, remotePath)
+	if isDir && !strings.HasSuffix(u.Path, "/") {
+		u.Path = u.Path + "/"
+	}
+
+	return u.String(), nil
+}
+
+func (d *WebDavDriver) request(method string, remotePath string, isDir bool, body io.Reader, header http.Header) (*http.Response, error) {
+
+	davUrl, err := d.davUrl(remotePath, isDir)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(method, davUrl, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		for _, value := range values {
+			request.Header.Add(name, value)
+		}
+	}
+
+	if d.config.Username != "" {
+		request.SetBasicAuth(d.config.Username, d.config.Password)
+	}
+
+	return d.client.Do(request)
+}
+
+func (d *WebDavDriver) Get(key string, rangeHeader string) (*http.Response, error) {
+
+	header := http.Header{}
+	if rangeHeader != "" {
+		header.Set("Range", rangeHeader)
+	}
+
+	resp, err := d.request("GET", path.Join(d.config.Path, key), false, nil, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("获取文件返回错误返回码%d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func (d *WebDavDriver) Put(key string, body io.Reader, length int64) error {
+
+	err := d.MkdirAll(path.Dir(key))
+	if err != nil {
+		return err
+	}
+
+	davUrl, err := d.davUrl(path.Join(d.config.Path, key), false)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("PUT", davUrl, body)
+	if err != nil {
+		return err
+	}
+	if length >= 0 {
+		request.ContentLength = length
+	}
+	request.Header.Set("Content-Type", "application/octet-stream")
+	if d.config.Username != "" {
+		request.SetBasicAuth(d.config.Username, d.config.Password)
+	}
+
+	resp, err := d.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("上传文件返回错误返回码%d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *WebDavDriver) Delete(key string) error {
+
+	resp, err := d.request("DELETE", path.Join(d.config.Path, key), false, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return errors.New("删除文件失败")
+	}
+
+	return nil
+}
+
+// RemoveDir 只删除空文件夹，WebDAV的DELETE会删除文件夹中所有的文件，因此需要先检查文件夹是否为空
+func (d *WebDavDriver) RemoveDir(key string) error {
+
+	file, err := d.Stat(key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if !file.IsDir {
+		return nil
+	}
+
+	entries, err := d.listDir(key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	if len(entries) > 0 {
+		return nil
+	}
+
+	return d.Delete(key)
+}
+
+func (d *WebDavDriver) Move(src string, dst string) error {
+	return d.transfer("MOVE", src, dst)
+}
+
+func (d *WebDavDriver) Copy(src string, dst string) error {
+	return d.transfer("COPY", src, dst)
+}
+
+// transfer 使用WebDAV的MOVE或者COPY方法在服务器上直接完成操作
+func (d *WebDavDriver) transfer(method string, src string, dst string) error {
+
+	// 只存在于数据库中的空文件夹在服务器上没有对应的目录
+	exists, err := d.exists(src)
+	if err != nil || !exists {
+		return err
+	}
+
+	err = d.MkdirAll(path.Dir(dst))
+	if err != nil {
+		return err
+	}
+
+	destination, err := d.davUrl(path.Join(d.config.Path, dst), false)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	header.Set("Destination", destination)
+	header.Set("Overwrite", "F")
+	header.Set("Depth", "infinity")
+
+	resp, err := d.request(method, path.Join(d.config.Path, src), false, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s文件返回错误返回码%d", method, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *WebDavDriver) exists(key string) (bool, error) {
+
+	header := http.Header{}
+	header.Set("Depth", "0")
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := d.request("PROPFIND", path.Join(d.config.Path, key), false, strings.NewReader(propfindBody), header)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return false, fmt.Errorf("获取文件信息返回错误返回码%d", resp.StatusCode)
+	}
+
+	return true, nil
+}
+
+func (d *WebDavDriver) Stat(key string) (*File, error) {
+
+	key = formatKey(key)
+
+	header := http.Header{}
+	header.Set("Depth", "0")
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := d.request("PROPFIND", path.Join(d.config.Path, key), false, strings.NewReader(propfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("文件%s不存在: %w", key, fs.ErrNotExist)
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("获取文件信息返回错误返回码%d", resp.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	multiStatus := davMultiStatus{}
+	err = xml.Unmarshal(data, &multiStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(multiStatus.Responses) == 0 {
+		return nil, errors.New("获取文件信息失败")
+	}
+
+	davfile := newDavFile(path.Base(key), multiStatus.Responses[0])
+
+	return &File{
+		Name:         davfile.Name,
+		IsDir:        davfile.IsDir,
+		Size:         davfile.Size,
+		AbsolutePath: key,
+		ModTime:      davfile.ModTime,
+		Hash:         davfile.ETag,
+	}, nil
+}
+
+// MkdirAll 逐级创建目录(包括配置的根目录)，已经存在的目录会被忽略
+func (d *WebDavDriver) MkdirAll(dir string) error {
+
+	dir = strings.Trim(path.Join(d.config.Path, dir), "/")
+	if dir == "" || dir == "." {
+		return nil
+	}
+
+	current := "/"
+	for _, name := range strings.Split(dir, "/") {
+		current = path.Join(current, name)
+
+		resp, err := d.request("MKCOL", current, true, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		// 405表示目录已经存在
+		if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return fmt.Errorf("创建目录返回错误返回码%d", resp.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+type davMultiStatus struct {
+	Responses []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"href"`
+	Propstats []davPropstat `xml:"propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"status"`
+	Prop   davProp `xml:"prop"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"collection"`
+	} `xml:"resourcetype"`
+	ContentLength int64  `xml:"getcontentlength"`
+	LastModified  string `xml:"getlastmodified"`
+	ETag          string `xml:"getetag"`
+}
+
+type DavFile struct {
+	Name    string
+	Size    int64
+	IsDir   bool
+	ModTime time.Time
+	ETag    string
+}
+
+// newDavFile 从PROPFIND返回的属性中解析出文件的信息，只使用状态为200的属性
+func newDavFile(name string, response davResponse) *DavFile {
+
+	davfile := &DavFile{
+		Name: name,
+	}
+
+	for _, propstat := range response.Propstats {
+		if !strings.Contains(propstat.Status, " 200 ") {
+			continue
+		}
+		if propstat.Prop.ResourceType.Collection != nil {
+			davfile.IsDir = true
+		} else {
+			davfile.Size = propstat.Prop.ContentLength
+		}
+		if propstat.Prop.LastModified != "" {
+			davfile.ModTime, _ = http.ParseTime(propstat.Prop.LastModified)
+		}
+		if propstat.Prop.ETag != "" {
+			davfile.ETag = strings.Trim(strings.TrimPrefix(propstat.Prop.ETag, "W/"), `"`)
+		}
+	}
+
+	return davfile
+}
+
+const propfindBody string = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/></d:prop></d:propfind>`
+
+func (d *WebDavDriver) listDir(key string) ([]*DavFile, error) {
+
+	header := http.Header{}
+	header.Set("Depth", "1")
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	remotePath := path.Join(d.config.Path, key)
+
+	resp, err := d.request("PROPFIND", remotePath, true, strings.NewReader(propfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("目录%s不存在: %w", key, fs.ErrNotExist)
+	}
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, errors.New("获取目录列表失败")
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	multiStatus := davMultiStatus{}
+	err = xml.Unmarshal(data, &multiStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	dirUrl, err := d.davUrl(remotePath, true)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(dirUrl)
+	if err != nil {
+		return nil, err
+	}
+	dirPath := strings.TrimRight(u.Path, "/")
+
+	davfiles := []*DavFile{}
+	for _, response := range multiStatus.Responses {
+
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, err
+		}
+
+		// 第一条记录是目录本身
+		hrefPath := strings.TrimRight(href.Path, "/")
+		if hrefPath == dirPath {
+			continue
+		}
+
+		davfiles = append(davfiles, newDavFile(path.Base(hrefPath), response))
+	}
+
+	return davfiles, nil
+}
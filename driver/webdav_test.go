@@ -0,0 +1,180 @@
+package driver
+
+import (
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func newTestWebDavDriver(t *testing.T) *WebDavDriver {
+
+	server := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	t.Cleanup(server.Close)
+
+	ddriver := &WebDavDriver{}
+	ddriver.initConfig(&WebDavDriverConfig{
+		Url:  server.URL,
+		Path: "/nextlist",
+		Key:  "key",
+		Host: "http://localhost:8081",
+	})
+
+	return ddriver
+}
+
+func Test_WebDavCheck(t *testing.T) {
+
+	ddriver := newTestWebDavDriver(t)
+
+	if err := ddriver.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_WebDavWalkDir(t *testing.T) {
+
+	ddriver := newTestWebDavDriver(t)
+
+	for key, content := range map[string]string{
+		"/a.txt":           "hello",
+		"/docs/b.txt":      "hello world",
+		"/docs/sub/c d.md": "#",
+	} {
+		if err := ddriver.Put(key, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, err := ddriver.WalkDir("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]*File{}
+	var collect func(file *File)
+	collect = func(file *File) {
+		files[file.AbsolutePath] = file
+		for _, child := range file.Childrens {
+			collect(child)
+		}
+	}
+	collect(root)
+
+	if len(files) != 6 {
+		t.Fatalf("expect 6 entries, got %d", len(files))
+	}
+
+	if file := files["/docs/b.txt"]; file == nil || file.IsDir || file.Size != 11 {
+		t.Fatalf("unexpected entry %+v", file)
+	}
+
+	if file := files["/docs/sub"]; file == nil || !file.IsDir {
+		t.Fatalf("unexpected entry %+v", file)
+	}
+
+	if file := files["/docs/sub/c d.md"]; file == nil || file.Name != "c d.md" {
+		t.Fatalf("unexpected entry %+v", file)
+	}
+
+	resp, err := ddriver.Get("/docs/b.txt", "bytes=6-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if string(data) != "world" {
+		t.Fatalf("unexpected content %s", data)
+	}
+
+	if err := ddriver.Delete("/docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := ddriver.WalkDir("/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.Childrens) != 1 || sub.Childrens[0].Name != "sub" {
+		t.Fatalf("unexpected children %+v", sub.Childrens)
+	}
+}
+
+func Test_WebDavMove(t *testing.T) {
+
+	ddriver := newTestWebDavDriver(t)
+
+	if err := ddriver.Put("/docs/a.txt", strings.NewReader("a"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ddriver.Move("/docs", "/archive/2021/docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 不存在的文件夹直接忽略
+	if err := ddriver.Move("/empty", "/archive/empty"); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := ddriver.WalkDir("/archive/2021/docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root.Childrens) != 1 || root.Childrens[0].AbsolutePath != "/archive/2021/docs/a.txt" {
+		t.Fatalf("unexpected children %+v", root.Childrens)
+	}
+}
+
+func Test_WebDavCopy(t *testing.T) {
+
+	ddriver := newTestWebDavDriver(t)
+
+	if err := ddriver.Put("/docs/sub/a.txt", strings.NewReader("a"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ddriver.Copy("/docs", "/backup/docs"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"/docs/sub/a.txt", "/backup/docs/sub/a.txt"} {
+		ok, err := ddriver.exists(key)
+		if err != nil || !ok {
+			t.Fatalf("%s should exist %v", key, err)
+		}
+	}
+}
+
+func Test_WebDavStat(t *testing.T) {
+
+	ddriver := newTestWebDavDriver(t)
+
+	if err := ddriver.Put("/docs/a.txt", strings.NewReader("hello"), 5); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := ddriver.Stat("/docs/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.IsDir || file.Size != 5 || file.AbsolutePath != "/docs/a.txt" || file.Hash == "" {
+		t.Fatalf("unexpected file %+v", file)
+	}
+
+	dir, err := ddriver.Stat("/docs")
+	if err != nil || !dir.IsDir {
+		t.Fatalf("unexpected dir %+v %v", dir, err)
+	}
+
+	if _, err := ddriver.Stat("/docs/b.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -3,15 +3,44 @@ package errors
 import "errors"
 
 var (
-	ErrFileNotFound        error = errors.New("文件不存在")
-	ErrNotEnoughPermission error = errors.New("权限不足")
-	ErrPasswordIsWrong     error = errors.New("密码错误")
-	ErrNotDirectoy         error = errors.New("不是文件夹")
-	ErrCreateDirConflict   error = errors.New("创建文件夹冲突")
-	ErrNotEmptyDirectoy    error = errors.New("不是空文件夹")
-	ErrFileExists          error = errors.New("文件已经存在")
-	ErrRegisterIsDisabled  error = errors.New("站点关闭了注册功能")
-	ErrNeedLogin           error = errors.New("需要先进行登录")
-	ErrUnAllowUrl          error = errors.New("不允许的跳转链接")
-	ErrUnSupportOperation  error = errors.New("不支持的操作")
+	ErrFileNotFound          error = errors.New("文件不存在")
+	ErrNotEnoughPermission   error = errors.New("权限不足")
+	ErrPasswordIsWrong       error = errors.New("密码错误")
+	ErrNotPasswordProtected  error = errors.New("文件没有设置密码")
+	ErrNotDirectoy           error = errors.New("不是文件夹")
+	ErrCreateDirConflict     error = errors.New("创建文件夹冲突")
+	ErrNotEmptyDirectoy      error = errors.New("不是空文件夹")
+	ErrFileExists            error = errors.New("文件已经存在")
+	ErrRegisterIsDisabled    error = errors.New("站点关闭了注册功能")
+	ErrNeedLogin             error = errors.New("需要先进行登录")
+	ErrUnAllowUrl            error = errors.New("不允许的跳转链接")
+	ErrUnSupportOperation    error = errors.New("不支持的操作")
+	ErrUnSupportDatabase     error = errors.New("不支持的数据库类型")
+	ErrMigrationIrreversible error = errors.New("不能回滚")
+	ErrUnAllowFileName       error = errors.New("不允许的文件名")
+	ErrMoveToSubDirectory    error = errors.New("不能移动到自己的子文件夹中")
+	ErrDeleteByTrash         error = errors.New("上传完成的文件删除后会进入回收站，不能直接删除存储中的文件")
+	ErrIsDirectory           error = errors.New("文件夹不能下载")
+	ErrShareNotFound         error = errors.New("分享不存在")
+	ErrShareExpired          error = errors.New("分享已经过期")
+	ErrShareExpireInPast     error = errors.New("过期时间不能早于当前时间")
+	ErrShareDownloadLimit    error = errors.New("分享的下载次数已经用完")
+	ErrSyncJobNotFound       error = errors.New("同步任务不存在")
+	ErrSyncJobRunning        error = errors.New("同步任务正在运行")
+	ErrInvalidCronSpec       error = errors.New("错误的定时表达式")
+	ErrSyncTaskNotFound      error = errors.New("同步不存在")
+	ErrSyncCanceled          error = errors.New("同步已经被取消")
+	ErrNotMounted            error = errors.New("该路径下没有挂载存储")
+	ErrFileUploaded          error = errors.New("文件已经上传完成")
+	ErrFileNotUploaded       error = errors.New("存储中没有找到上传的文件")
+	ErrUploadNotFound        error = errors.New("分片上传不存在")
+	ErrInvalidPartNumber     error = errors.New("分片编号必须在1到10000之间")
+	ErrEmptyUploadParts      error = errors.New("没有上传任何分片")
+	ErrParentInTrash         error = errors.New("父文件夹已经被删除，请先还原父文件夹")
+	ErrUserNotFound          error = errors.New("用户不存在")
+	ErrUserDisabled          error = errors.New("用户已经被禁用")
+	ErrUnknownRole           error = errors.New("未知的角色")
+	ErrContainsOthersFiles   error = errors.New("文件夹中包含其他用户的文件")
+	ErrOperateSelf           error = errors.New("不能对自己的账号进行这个操作")
+	ErrPathInTrash           error = errors.New("回收站中的文件仍然保存在这个位置，请先还原或者彻底删除")
 )
@@ -5,19 +5,24 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	echo "github.com/labstack/echo/v4"
 	echo_middleware "github.com/labstack/echo/v4/middleware"
 
 	"github.com/lixiaofei123/nextlist/configs"
+	"github.com/lixiaofei123/nextlist/database"
 	"github.com/lixiaofei123/nextlist/driver"
 	"github.com/lixiaofei123/nextlist/models"
 	"github.com/lixiaofei123/nextlist/services"
 	"github.com/lixiaofei123/nextlist/web/controller"
+	"github.com/lixiaofei123/nextlist/web/dav"
 	"github.com/lixiaofei123/nextlist/web/middleware"
 	"github.com/lixiaofei123/nextlist/web/mvc"
-	"gorm.io/driver/mysql"
+	"github.com/lixiaofei123/nextlist/web/tus"
+	"github.com/robfig/cron/v3"
 	"gorm.io/gorm"
 	gormlogger "gorm.io/gorm/logger"
 )
@@ -35,10 +40,7 @@ func initApp() (driver.Driver, bool, error) {
 		return nil, false, err
 	}
 
-	driverConfig := configs.GlobalConfig.DriverConfig
-	driverName := driverConfig.Name
-
-	sdriver, err := driver.GetDriver(driverName, driverConfig.Config)
+	sdriver, err := loadDriver(configs.GlobalConfig)
 	if err != nil {
 		log.Panic(err)
 	}
@@ -46,6 +48,34 @@ func initApp() (driver.Driver, bool, error) {
 	return sdriver, debug, nil
 }
 
+// loadDriver 没有配置挂载点时直接使用driver中配置的驱动，否则driver中配置的驱动挂载在根目录下
+func loadDriver(config *configs.Config) (driver.Driver, error) {
+
+	if len(config.Mounts) == 0 {
+		return driver.GetDriver(config.DriverConfig.Name, config.DriverConfig.Config)
+	}
+
+	mounts := []*driver.Mount{}
+
+	if config.DriverConfig.Name != "" {
+		sdriver, err := driver.GetDriver(config.DriverConfig.Name, config.DriverConfig.Config)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, &driver.Mount{Path: "/", Driver: sdriver})
+	}
+
+	for _, mountConfig := range config.Mounts {
+		sdriver, err := driver.GetDriver(mountConfig.Name, mountConfig.Config)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, &driver.Mount{Path: mountConfig.Path, Driver: sdriver})
+	}
+
+	return driver.NewMountDriver(mounts)
+}
+
 func CustomHTTPErrorHandler(err error, c echo.Context) {
 	c.Response().Status = http.StatusInternalServerError
 	c.Response().Write([]byte(fmt.Sprintf(`{"code":%d,"data":"%s"}`, http.StatusInternalServerError, err.Error())))
@@ -53,6 +83,13 @@ func CustomHTTPErrorHandler(err error, c echo.Context) {
 
 func main() {
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrateCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// 初始化echo
 	e := echo.New()
 	e.IPExtractor = func(r *http.Request) string {
@@ -77,6 +114,8 @@ func main() {
 		AllowHeaders:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD"},
 		AllowCredentials: true,
+		// 断点续传需要读取这些响应头
+		ExposeHeaders: []string{"Location", "Upload-Offset", "Upload-Length", "Upload-Expires", "Tus-Resumable", "Tus-Version", "Tus-Extension"},
 	}))
 
 	e.HTTPErrorHandler = CustomHTTPErrorHandler
@@ -99,13 +138,7 @@ func main() {
 			gormDebugLevel = gormlogger.Info
 		}
 
-		db, err := gorm.Open(mysql.Open(fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-			configs.GlobalConfig.DataBase.Mysql.Username,
-			configs.GlobalConfig.DataBase.Mysql.Password,
-			configs.GlobalConfig.DataBase.Mysql.Url,
-			configs.GlobalConfig.DataBase.Mysql.Port,
-			configs.GlobalConfig.DataBase.Mysql.Database,
-		)), &gorm.Config{
+		db, err := database.Open(&configs.GlobalConfig.DataBase, &gorm.Config{
 			Logger: gormlogger.Default.LogMode(gormDebugLevel),
 		})
 
@@ -113,47 +146,113 @@ func main() {
 			log.Panic(err)
 		}
 
-		err = db.AutoMigrate(&models.User{})
+		_, err = database.Migrate(db)
 		if err != nil {
 			log.Panic(err)
 		}
 
-		err = db.AutoMigrate(&models.File{})
+		sdriver, err = loadDriver(configs.GlobalConfig)
 		if err != nil {
 			log.Panic(err)
 		}
 
-		driverConfig := configs.GlobalConfig.DriverConfig
-		driverName := driverConfig.Name
-
-		sdriver, err = driver.GetDriver(driverName, driverConfig.Config)
+		err = sdriver.InitDriver(apiv1, db)
 		if err != nil {
 			log.Panic(err)
 		}
 
-		err = sdriver.InitDriver(apiv1, db)
+		fileSrv := services.NewFileService(db, sdriver)
+		userSrv := services.NewUserService(db, fileSrv)
+		shareSrv := services.NewShareService(db, fileSrv)
+		syncSrv := services.NewSyncService(db, fileSrv)
+		uploadSrv := services.NewUploadService(db, fileSrv, sdriver)
+
+		err = syncSrv.Start()
 		if err != nil {
 			log.Panic(err)
 		}
 
-		userSrv := services.NewUserService(db)
-		fileSrv := services.NewFileService(db, sdriver)
-
 		user := apiv1.Group("/user")
-		user.Use(middleware.NotMustAuthHandler)
+		user.Use(middleware.NotMustAuthHandler(userSrv))
 		mvc.New(user).Handle(controller.NewUserController(userSrv))
 
 		file := apiv1.Group("/file")
-		file.Use(middleware.NotMustAuthHandler)
+		file.Use(middleware.NotMustAuthHandler(userSrv))
 		mvc.New(file).Handle(controller.NewFileController(fileSrv))
 
+		download := e.Group("/d")
+		download.Use(middleware.NotMustAuthHandler(userSrv))
+		mvc.New(download).Handle(controller.NewDownloadController(fileSrv))
+
 		adminapi := apiv1.Group("/admin")
-		adminapi.Use(middleware.AuthHandler)
-		mvc.New(adminapi).Handle(controller.NewAdminFileController(fileSrv, sdriver))
+		adminapi.Use(middleware.AuthHandler, middleware.ActiveUserHandler(userSrv))
+		// 每个接口需要的角色由控制器的RequiredRole决定
+		adminApp := mvc.New(adminapi).Use(middleware.ControllerRole)
+		adminApp.Handle(controller.NewAdminFileController(fileSrv, sdriver))
+		adminApp.Handle(controller.NewAdminShareController(shareSrv))
+		adminApp.Handle(controller.NewAdminSyncController(syncSrv))
+		adminApp.Handle(controller.NewAdminUploadController(uploadSrv))
+		adminApp.Handle(controller.NewAdminUserController(userSrv))
+
+		tusSrv, err := tus.New(configs.GlobalConfig.UploadConfig.TempDir, fileSrv, sdriver)
+		if err != nil {
+			log.Panic(err)
+		}
+		tusSrv.Register(adminapi, middleware.RoleHandler(models.AdminRole))
+
+		share := apiv1.Group("/share")
+		mvc.New(share).Handle(controller.NewShareController(shareSrv))
 
 		siteapi := apiv1.Group("/site")
 		mvc.New(siteapi).Handle(controller.NewSiteController(userSrv))
 
+		// 定时彻底删除回收站中过期的文件，以及清理过期的断点续传数据、分片上传和没有上传完成的文件
+		retentionDays := configs.GlobalConfig.TrashConfig.RetentionDays
+		if retentionDays <= 0 {
+			retentionDays = 30
+		}
+		trashCron := cron.New()
+		trashCron.AddFunc("@hourly", func() {
+			count, err := fileSrv.PurgeTrash(time.Now().AddDate(0, 0, -retentionDays))
+			if err != nil {
+				log.Printf("清理回收站失败: %s", err)
+			}
+			if count > 0 {
+				log.Printf("已经从回收站中彻底删除%d个文件", count)
+			}
+		})
+		trashCron.AddFunc("@hourly", tusSrv.CleanExpired)
+		multipartExpireDays := configs.GlobalConfig.UploadConfig.MultipartExpireDays
+		if multipartExpireDays <= 0 {
+			multipartExpireDays = 7
+		}
+		trashCron.AddFunc("@hourly", func() {
+			count, err := uploadSrv.AbortExpired(time.Now().AddDate(0, 0, -multipartExpireDays))
+			if err != nil {
+				log.Printf("取消过期的分片上传失败: %s", err)
+			}
+			if count > 0 {
+				log.Printf("已经取消%d个过期的分片上传", count)
+			}
+		})
+		readyExpireDays := configs.GlobalConfig.UploadConfig.ReadyExpireDays
+		if readyExpireDays <= 0 {
+			readyExpireDays = 7
+		}
+		trashCron.AddFunc("@hourly", func() {
+			count, err := fileSrv.PurgeUploading(time.Now().AddDate(0, 0, -readyExpireDays))
+			if err != nil {
+				log.Printf("清理没有上传完成的文件失败: %s", err)
+			}
+			if count > 0 {
+				log.Printf("已经删除%d个没有上传完成的文件", count)
+			}
+		})
+		trashCron.Start()
+
+		// WebDAV的请求方法无法通过echo的路由注册
+		e.Pre(dav.New("/dav", fileSrv, userSrv, sdriver).Handler)
+
 		log.Println("程序已经运行......")
 	}
 
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/lixiaofei123/nextlist/configs"
+	"github.com/lixiaofei123/nextlist/database"
+)
+
+const migrateUsage string = `用法: nextlist migrate <命令>
+
+命令:
+  status     查看数据库迁移的执行状态(默认)
+  up         执行所有还没有执行的迁移
+  down [n]   回滚最近执行的n个迁移，默认为1
+`
+
+// migrateCommand 处理nextlist migrate子命令，升级前可以先查看有哪些迁移需要执行，出现问题时可以手动回滚
+func migrateCommand(args []string) error {
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), migrateUsage)
+	}
+	flags.Parse(args)
+
+	err := configs.InitConfig()
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Open(&configs.GlobalConfig.DataBase, nil)
+	if err != nil {
+		return err
+	}
+
+	switch flags.Arg(0) {
+	case "", "status":
+		statuses, err := database.Status(db)
+		if err != nil {
+			return err
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "版本\t名称\t执行时间")
+		for _, status := range statuses {
+			appliedAt := "未执行"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return writer.Flush()
+	case "up":
+		done, err := database.Migrate(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("执行了%d个迁移\n", len(done))
+		return nil
+	case "down":
+		steps := 1
+		if flags.Arg(1) != "" {
+			steps, err = strconv.Atoi(flags.Arg(1))
+			if err != nil || steps < 1 {
+				return errors.New("回滚的数量必须是正整数")
+			}
+		}
+		done, err := database.Rollback(db, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("回滚了%d个迁移\n", len(done))
+		return nil
+	}
+
+	flags.Usage()
+	os.Exit(2)
+	return nil
+}
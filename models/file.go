@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/lixiaofei123/nextlist/driver"
+	"gorm.io/gorm"
 )
 
 type Permission int
@@ -26,18 +27,40 @@ const (
 type File struct {
 	ID             string                `gorm:"primaryKey,size:36" json:"id,omitempty"`
 	UserName       string                `gorm:"size:20" json:"userName,omitempty"`
-	Name           string                `gorm:"size:200;not null;uniqueIndex:idx_parent_name" json:"name"`
-	ParentId       string                `gorm:"size:36;default:'';uniqueIndex:idx_parent_name" json:"parentId"`
+	Name           string                `gorm:"size:200;not null;uniqueIndex:idx_parent_name_trash" json:"name"`
+	ParentId       string                `gorm:"size:36;default:'';uniqueIndex:idx_parent_name_trash" json:"parentId"`
 	AbsolutePath   string                `gorm:"size:300;not null;" json:"absolutePath"`
 	IsDict         sql.NullBool          `gorm:"not null;default:false" json:"isDict"`
 	Children       []*File               `gorm:"-" json:"children"`
 	FileType       string                `gorm:"size:100;not null;default:''" json:"fileType"`
 	FileSize       int64                 `gorm:"not null;default:0" json:"fileSize"`
-	Permission     Permission            `grom:"not null;default:0" json:"permission,omitempty"`
-	FileStatus     FileStatus            `grom:"not null;default:1" json:"fileStatus,omitempty"`
+	Hash           string                `gorm:"size:128;not null;default:''" json:"hash,omitempty"`
+	Permission     Permission            `gorm:"not null;default:0" json:"permission,omitempty"`
+	FileStatus     FileStatus            `gorm:"not null" json:"fileStatus,omitempty"`
 	LastModifyTime time.Time             `gorm:"not null;" json:"createAt,omitempty"`
 	DownloadUrls   []*driver.DownloadUrl `gorm:"-" json:"downloadUrls"`
-	Password       string                `gorm:"size:30" json:"-"`
+	Password       string                `gorm:"size:100" json:"-"`
+	DeletedAt      gorm.DeletedAt        `gorm:"index" json:"deletedAt,omitempty"`
+	TrashId        string                `gorm:"size:36;not null;default:'';index;uniqueIndex:idx_parent_name_trash" json:"-"`
+	SyncGen        int64                 `gorm:"not null;default:0" json:"-"`
+	TrashKey       string                `gorm:"size:300;not null;default:''" json:"-"`
+}
+
+type DeleteFailure struct {
+	AbsolutePath string `json:"absolutePath"`
+	Reason       string `json:"reason"`
+}
+
+type DeleteReport struct {
+	Deleted  int              `json:"deleted"`
+	Failures []*DeleteFailure `json:"failures"`
+}
+
+type SyncResult struct {
+	Scanned int `json:"scanned"`
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
 }
 
 type PageResult struct {
@@ -47,3 +70,9 @@ type PageResult struct {
 	List      interface{}            `json:"list"`
 	Extend    map[string]interface{} `json:"extend,omitempty"`
 }
+
+// UnlockToken 是访问加密文件夹的解锁令牌，可以代替密码使用
+type UnlockToken struct {
+	Token    string    `json:"token"`
+	ExpireAt time.Time `json:"expireAt"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// SchemaMigration 记录已经执行过的数据库迁移
+type SchemaMigration struct {
+	Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	AppliedAt time.Time `gorm:"not null" json:"appliedAt"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type Share struct {
+	ID           string     `gorm:"primaryKey,size:36" json:"id,omitempty"`
+	UserName     string     `gorm:"size:20;index" json:"userName,omitempty"`
+	FileId       string     `gorm:"size:36;not null" json:"fileId"`
+	File         *File      `gorm:"-" json:"file,omitempty"`
+	ExpireAt     *time.Time `json:"expireAt,omitempty"`
+	MaxDownloads int        `gorm:"not null;default:0" json:"maxDownloads"`
+	Downloads    int        `gorm:"not null;default:0" json:"downloads"`
+	Password     string     `gorm:"size:100" json:"-"`
+	HasPassword  bool       `gorm:"-" json:"hasPassword"`
+	CreatedAt    time.Time  `json:"createAt,omitempty"`
+}
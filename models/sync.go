@@ -0,0 +1,52 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type SyncJob struct {
+	ID        string       `gorm:"primaryKey,size:36" json:"id,omitempty"`
+	UserName  string       `gorm:"size:20" json:"userName,omitempty"`
+	Path      string       `gorm:"size:300;not null" json:"path"`
+	Spec      string       `gorm:"size:100;not null" json:"spec"`
+	Enable    sql.NullBool `gorm:"not null;default:true" json:"enable"`
+	LastRun   *SyncRun     `gorm:"-" json:"lastRun,omitempty"`
+	CreatedAt time.Time    `json:"createAt,omitempty"`
+}
+
+type SyncRun struct {
+	ID      string     `gorm:"primaryKey,size:36" json:"id,omitempty"`
+	JobId   string     `gorm:"size:36;index" json:"jobId"`
+	TaskId  string     `gorm:"size:36" json:"taskId,omitempty"`
+	StartAt time.Time  `gorm:"not null" json:"startAt"`
+	EndAt   *time.Time `json:"endAt,omitempty"`
+	Scanned int        `gorm:"not null;default:0" json:"scanned"`
+	Added   int        `gorm:"not null;default:0" json:"added"`
+	Updated int        `gorm:"not null;default:0" json:"updated"`
+	Removed int        `gorm:"not null;default:0" json:"removed"`
+	Error   string     `gorm:"size:1000" json:"error,omitempty"`
+}
+
+type SyncTaskStatus int
+
+const (
+	SYNCRUNNING  SyncTaskStatus = 0
+	SYNCSUCCESS  SyncTaskStatus = 1
+	SYNCFAILED   SyncTaskStatus = 2
+	SYNCCANCELED SyncTaskStatus = 3
+)
+
+// SyncTask 是一次正在后台运行(或者已经结束)的同步，只保存在内存中
+type SyncTask struct {
+	ID          string         `json:"id"`
+	JobId       string         `json:"jobId,omitempty"`
+	UserName    string         `json:"userName,omitempty"`
+	Path        string         `json:"path"`
+	Status      SyncTaskStatus `json:"status"`
+	CurrentPath string         `json:"currentPath,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	StartAt     time.Time      `json:"startAt"`
+	EndAt       *time.Time     `json:"endAt,omitempty"`
+	SyncResult
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// MultipartUpload 是一次还没有完成的分片上传，UploadId为存储返回的分片上传ID
+type MultipartUpload struct {
+	ID        string    `gorm:"primaryKey,size:36" json:"id,omitempty"`
+	UserName  string    `gorm:"size:20" json:"userName,omitempty"`
+	FileId    string    `gorm:"size:36;index" json:"fileId"`
+	Key       string    `gorm:"size:300;not null" json:"key"`
+	UploadId  string    `gorm:"size:1024;not null" json:"-"`
+	CreatedAt time.Time `gorm:"index" json:"createAt,omitempty"`
+}
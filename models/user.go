@@ -15,18 +15,46 @@ const (
 	UserRole       Role = "user"
 )
 
+// roleLevels 角色的级别，级别高的角色拥有级别低的角色的所有权限
+var roleLevels = map[Role]int{
+	UserRole:       1,
+	AdminRole:      2,
+	SuperAdminRole: 3,
+}
+
+// Includes 判断角色是否拥有role的权限，未知的角色没有任何权限
+func (r Role) Includes(role Role) bool {
+	return roleLevels[r] > 0 && roleLevels[r] >= roleLevels[role]
+}
+
+func (r Role) Valid() bool {
+	return roleLevels[r] > 0
+}
+
 type User struct {
 	ID        string       `gorm:"primaryKey,size:36" json:"id,omitempty"`
 	UserName  string       `gorm:"size:20;uniqueIndex:idx_username" json:"userName,omitempty" validate:"required,min=5,max=20"`
 	ShowName  string       `gorm:"size:40" json:"showName,omitempty" validate:"min=5,max=40"`
 	Email     string       `gorm:"size:40;uniqueIndex:idx_email" json:"email,omitempty" validate:"required,email,max=40"`
 	Tel       string       `gorm:"size:11;uniqueIndex:idx_tel" json:"tel,omitempty" validate:"required,len=11"`
-	Password  string       `gorm:"size:32" json:"password,omitempty" validate:"required,min=10,max=20"`
+	Password  string       `gorm:"size:100" json:"password,omitempty" validate:"required,min=10,max=20"`
 	Role      Role         `gorm:"size:15" json:"role,omitempty"`
 	Enable    sql.NullBool `json:"enable,omitempty"`
 	CreatedAt time.Time    `json:"createAt,omitempty"`
 }
 
+// Disabled 早期版本注册的用户没有设置Enable，这些用户都是启用的
+func (u *User) Disabled() bool {
+	return u.Enable.Valid && !u.Enable.Bool
+}
+
+// DeleteUserResult 是删除用户的结果，文件转交给其他用户时Report为空
+type DeleteUserResult struct {
+	User        *User         `json:"user"`
+	Transferred int64         `json:"transferred"`
+	Report      *DeleteReport `json:"report,omitempty"`
+}
+
 type JWTClaims struct {
 	Role     string `json:"role"`
 	Email    string `json:"email"`
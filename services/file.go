@@ -1,10 +1,20 @@
 package services
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,6 +23,7 @@ import (
 	fileerr "github.com/lixiaofei123/nextlist/errors"
 	models "github.com/lixiaofei123/nextlist/models"
 	"github.com/lixiaofei123/nextlist/utils"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -23,8 +34,14 @@ type FileService interface {
 
 	FindById(username string, password, fileId string) (*models.File, error)
 
+	UnlockFile(fileId, password string) (*models.UnlockToken, error)
+
 	FindByPath(path string) (*models.File, error)
 
+	FindSharedFile(rootId, fileId string) (*models.File, error)
+
+	FindSharedChildFiles(rootId, fileId string, page, count int) (*models.PageResult, error)
+
 	BaseInfo(fileId string) (*models.File, error)
 
 	CreateDictory(username, parentId, name string, permission models.Permission, password string) (*models.File, error)
@@ -33,13 +50,43 @@ type FileService interface {
 
 	UpdateFileStatus(username string, fileId string, status models.FileStatus) (*models.File, error)
 
+	FinishUpload(username string, fileId string, fileSize int64) (*models.File, error)
+
+	ConfirmUpload(username string, fileId string) (*models.File, error)
+
+	FindUploadingFile(username string, fileId string) (*models.File, error)
+
+	TouchUploading(username string, fileId string) error
+
 	DeleteFile(username, fileId string) (*models.File, error)
 
+	TrashFile(username, fileId string) (*models.File, error)
+
+	DeleteFileRecursive(username, fileId string) (*models.DeleteReport, error)
+
+	DeleteUserFiles(username string) (*models.DeleteReport, error)
+
+	ListTrash(username string, page, count int) (*models.PageResult, error)
+
+	RestoreFile(username, fileId string) (*models.File, error)
+
+	PurgeFile(username, fileId string) (*models.File, error)
+
+	PurgeTrash(before time.Time) (int, error)
+
+	PurgeUploading(before time.Time) (int, error)
+
+	MoveFile(username, fileId, parentId, name string) (*models.File, error)
+
+	RenameFile(username, fileId, name string) (*models.File, error)
+
+	CopyFile(username, fileId, parentId, name string) (*models.File, error)
+
 	SearchFile(username, keyword string, page, count int) (*models.PageResult, error)
 
 	CountFiles() (map[string]int64, error)
 
-	SyncFiles(username string, key string) error
+	SyncFiles(ctx context.Context, username string, key string, progress SyncProgress) (*models.SyncResult, error)
 }
 
 type fileService struct {
@@ -56,7 +103,7 @@ func NewFileService(db *gorm.DB, driver driver.Driver) FileService {
 
 func (f *fileService) CountFiles() (map[string]int64, error) {
 
-	rows, err := f.db.Raw("select file_type as fileType,count(*) as count from files where is_dict = false  group by file_type").Rows()
+	rows, err := f.db.Model(&models.File{}).Select("file_type, count(*)").Where("is_dict = ?", false).Group("file_type").Rows()
 	if err != nil {
 		return nil, err
 	}
@@ -67,13 +114,15 @@ func (f *fileService) CountFiles() (map[string]int64, error) {
 	var results map[string]int64 = map[string]int64{}
 
 	for rows.Next() {
-		rows.Scan(&fileType, &count)
+		if err := rows.Scan(&fileType, &count); err != nil {
+			return nil, err
+		}
 		results[fileType] = count
 	}
 
 	// 总量
 	var totalSize int64
-	err = f.db.Raw("select sum(file_size) from files").Scan(&totalSize).Error
+	err = f.db.Model(&models.File{}).Select("coalesce(sum(file_size), 0)").Scan(&totalSize).Error
 	if err != nil {
 		return nil, err
 	}
@@ -140,7 +189,7 @@ func (f *fileService) FindChildFiles(username string, fileId string, password st
 
 	if file.IsDict.Bool {
 
-		if file.Permission == models.PASSWORD && password != file.Password {
+		if file.Permission == models.PASSWORD && !checkFilePassword(file, password) {
 			return nil, fileerr.ErrPasswordIsWrong
 		}
 
@@ -148,47 +197,131 @@ func (f *fileService) FindChildFiles(username string, fileId string, password st
 			return nil, fileerr.ErrNotEnoughPermission
 		}
 
-		files := []*models.File{}
-		if err := f.db.Model(&models.File{}).Where("parent_id = ?", fileId).Order("last_modify_time asc").Offset((page - 1) * count).Limit(count).Find(&files).Error; err != nil {
-			if !errors.Is(err, gorm.ErrRecordNotFound) {
-				return nil, err
-			}
+		result, err := f.childFiles(username, fileId, page, count)
+		if err != nil {
+			return nil, err
 		}
 
-		for index, file := range files {
-			if !file.IsDict.Bool {
-				files[index].DownloadUrls, _ = f.driver.DownloadUrl(file.AbsolutePath)
-			}
+		result.Extend["permission"] = int(file.Permission)
+
+		return result, nil
+	}
+
+	return nil, fileerr.ErrNotDirectoy
+
+}
+
+// visibleFiles 还没有上传完成的文件只有上传者自己可以看到
+func visibleFiles(db *gorm.DB, username string) *gorm.DB {
+	if username == "" {
+		return db.Where("file_status = ?", models.SUCCESS)
+	}
+	return db.Where("(file_status = ? or user_name = ?)", models.SUCCESS, username)
+}
+
+func (f *fileService) childFiles(username string, fileId string, page, count int) (*models.PageResult, error) {
+
+	files := []*models.File{}
+	if err := visibleFiles(f.db.Model(&models.File{}), username).Where("parent_id = ?", fileId).Order("last_modify_time asc").Offset((page - 1) * count).Limit(count).Find(&files).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	for index, file := range files {
+		if !file.IsDict.Bool {
+			files[index].DownloadUrls, _ = f.driver.DownloadUrl(file.AbsolutePath)
+		}
+
+	}
+
+	// 查询数量
+	var total int64
+	if err := visibleFiles(f.db.Model(&models.File{}), username).Where("parent_id = ?", fileId).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	var extend map[string]interface{} = map[string]interface{}{}
+	extend["fileid"] = fileId
 
+	return &models.PageResult{
+		Total:     int(total),
+		Page:      page,
+		PageCount: count,
+		List:      files,
+		Extend:    extend,
+	}, nil
+}
+
+// FindSharedFile 查找分享的文件(夹)rootId中的文件，fileId为空时返回rootId本身，不检查文件本身的权限
+func (f *fileService) FindSharedFile(rootId, fileId string) (*models.File, error) {
+
+	if rootId == "" {
+		return nil, fileerr.ErrFileNotFound
+	}
+
+	root := &models.File{ID: rootId}
+	if err := f.db.Where(root).First(root).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrFileNotFound
 		}
+		return nil, err
+	}
 
-		// 查询数量
-		var total int64
-		if err := f.db.Model(&models.File{}).Where("parent_id = ?", fileId).Count(&total).Error; err != nil {
+	file := root
+	if fileId != "" && fileId != rootId {
+		file = &models.File{ID: fileId}
+		if err := f.db.Where(file).First(file).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fileerr.ErrFileNotFound
+			}
 			return nil, err
 		}
 
-		var extend map[string]interface{} = map[string]interface{}{}
-		extend["fileid"] = fileId
-		extend["permission"] = int(file.Permission)
+		// 只能访问分享的文件夹中的文件
+		if !root.IsDict.Bool || !strings.HasPrefix(file.AbsolutePath, root.AbsolutePath+"/") {
+			return nil, fileerr.ErrNotEnoughPermission
+		}
+	}
 
-		return &models.PageResult{
-			Total:     int(total),
-			Page:      page,
-			PageCount: count,
-			List:      files,
-			Extend:    extend,
-		}, nil
+	if file.FileStatus != models.SUCCESS {
+		return nil, fileerr.ErrFileNotFound
 	}
 
-	return nil, fileerr.ErrNotDirectoy
+	if !file.IsDict.Bool {
+		file.DownloadUrls, _ = f.driver.DownloadUrl(file.AbsolutePath)
+	}
+
+	return file, nil
+}
+
+// FindSharedChildFiles 列出分享的文件夹rootId中fileId目录下的文件
+func (f *fileService) FindSharedChildFiles(rootId, fileId string, page, count int) (*models.PageResult, error) {
+
+	if page < 1 {
+		page = 1
+	}
+
+	if count < 1 || count > 50 {
+		count = 50
+	}
+
+	file, err := f.FindSharedFile(rootId, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !file.IsDict.Bool {
+		return nil, fileerr.ErrNotDirectoy
+	}
 
+	return f.childFiles("", file.ID, page, count)
 }
 
 func (f *fileService) SearchFile(username, keyword string, page, count int) (*models.PageResult, error) {
 
 	allFiles := []*models.File{}
-	if err := f.db.Model(&models.File{}).Where("name like ?", fmt.Sprintf("%%%s%%", keyword)).Offset((page - 1) * count).Limit(count).Find(&allFiles).Error; err != nil {
+	if err := visibleFiles(f.db.Model(&models.File{}), username).Where("name like ?", fmt.Sprintf("%%%s%%", keyword)).Offset((page - 1) * count).Limit(count).Find(&allFiles).Error; err != nil {
 		return nil, err
 	}
 
@@ -201,7 +334,7 @@ func (f *fileService) SearchFile(username, keyword string, page, count int) (*mo
 	}
 
 	var total int64
-	if err := f.db.Model(&models.File{}).Where("name like ?", fmt.Sprintf("%%%s%%", keyword)).Count(&total).Error; err != nil {
+	if err := visibleFiles(f.db.Model(&models.File{}), username).Where("name like ?", fmt.Sprintf("%%%s%%", keyword)).Count(&total).Error; err != nil {
 		return nil, err
 	}
 
@@ -226,7 +359,7 @@ func (f *fileService) FindById(username string, password, fileId string) (*model
 		return nil, err
 	}
 
-	if file.Permission == models.PASSWORD && password != file.Password {
+	if file.Permission == models.PASSWORD && !checkFilePassword(file, password) {
 		return nil, fileerr.ErrPasswordIsWrong
 	}
 
@@ -241,6 +374,36 @@ func (f *fileService) FindById(username string, password, fileId string) (*model
 	return file, nil
 }
 
+// UnlockFile 校验加密文件(夹)的密码，返回一个短期有效的解锁令牌。令牌可以代替密码访问这个文件夹
+// 以及其中继承了相同密码的文件，修改密码后令牌会失效
+func (f *fileService) UnlockFile(fileId, password string) (*models.UnlockToken, error) {
+
+	file := &models.File{
+		ID: fileId,
+	}
+
+	if err := f.db.Where(file).First(file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	if file.Permission != models.PASSWORD {
+		return nil, fileerr.ErrNotPasswordProtected
+	}
+
+	if !checkFilePassword(file, password) {
+		return nil, fileerr.ErrPasswordIsWrong
+	}
+
+	expireAt := time.Now().Add(unlockTokenExpire)
+	return &models.UnlockToken{
+		Token:    signUnlockToken(file.Password, expireAt.Unix()),
+		ExpireAt: expireAt,
+	}, nil
+}
+
 func (f *fileService) BaseInfo(fileId string) (*models.File, error) {
 
 	file := &models.File{
@@ -305,13 +468,132 @@ func (f *fileService) UpdateFileStatus(username string, fileId string, status mo
 
 }
 
+// FinishUpload 上传完成后由服务端调用，同时记录文件的实际大小
+func (f *fileService) FinishUpload(username string, fileId string, fileSize int64) (*models.File, error) {
+	return f.finishUpload(username, fileId, fileSize, "")
+}
+
+// ConfirmUpload 客户端上传完成后调用，驱动支持Stat时会先检查存储中是否真的存在这个文件，
+// 并记录文件的实际大小以及存储返回的ETag，避免上传中断的文件出现在列表中
+func (f *fileService) ConfirmUpload(username string, fileId string) (*models.File, error) {
+
+	file, err := f.FindUploadingFile(username, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	stater, ok := f.driver.(driver.Stater)
+	if !ok {
+		return f.finishUpload(username, fileId, file.FileSize, "")
+	}
+
+	info, err := stater.Stat(file.AbsolutePath)
+	if errors.Is(err, fileerr.ErrUnSupportOperation) {
+		return f.finishUpload(username, fileId, file.FileSize, "")
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fileerr.ErrFileNotUploaded
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir {
+		return nil, fileerr.ErrFileNotUploaded
+	}
+
+	return f.finishUpload(username, fileId, info.Size, info.Hash)
+}
+
+func (f *fileService) finishUpload(username string, fileId string, fileSize int64, hash string) (*models.File, error) {
+
+	file := &models.File{
+		ID: fileId,
+	}
+
+	if err := f.db.Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Where(file).First(file).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fileerr.ErrFileNotFound
+			}
+			return err
+		}
+
+		if file.UserName != username {
+			return fileerr.ErrNotEnoughPermission
+		}
+
+		file.FileStatus = models.SUCCESS
+		file.FileSize = fileSize
+		file.Hash = hash
+		file.LastModifyTime = time.Now()
+		file.SyncGen = time.Now().UnixNano()
+		return tx.Select("file_status", "file_size", "hash", "last_modify_time", "sync_gen").Updates(file).Error
+
+	}); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// FindUploadingFile 返回用户自己的还没有上传完成的文件
+func (f *fileService) FindUploadingFile(username string, fileId string) (*models.File, error) {
+
+	if fileId == "" {
+		return nil, fileerr.ErrFileNotFound
+	}
+
+	file := &models.File{
+		ID: fileId,
+	}
+
+	if err := f.db.Where(file).First(file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	if file.UserName != username {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	if file.IsDict.Bool {
+		return nil, fileerr.ErrIsDirectory
+	}
+
+	if file.FileStatus != models.READY {
+		return nil, fileerr.ErrFileUploaded
+	}
+
+	return file, nil
+}
+
+// TouchUploading 更新还没有上传完成的文件的修改时间，正在断点续传的文件不会被当作上传失败的文件清理
+func (f *fileService) TouchUploading(username string, fileId string) error {
+	return f.db.Model(&models.File{}).Where("id = ? and user_name = ? and file_status = ?", fileId, username, models.READY).
+		Update("last_modify_time", time.Now()).Error
+}
+
 func (f *fileService) DeleteFile(username, fileId string) (*models.File, error) {
+	return f.deleteFile(username, fileId, false)
+}
+
+// TrashFile 将文件(夹)以及其中所有的文件在一个事务中放入回收站，文件夹中有其他用户的文件时不允许删除
+func (f *fileService) TrashFile(username, fileId string) (*models.File, error) {
+	return f.deleteFile(username, fileId, true)
+}
+
+func (f *fileService) deleteFile(username, fileId string, recursive bool) (*models.File, error) {
 
 	if username == "" {
 		return nil, fileerr.ErrNotEnoughPermission
 	}
 
 	var file *models.File = &models.File{ID: fileId}
+	moves := [][2]string{}
 
 	if err := f.db.Transaction(func(tx *gorm.DB) error {
 
@@ -328,7 +610,7 @@ func (f *fileService) DeleteFile(username, fileId string) (*models.File, error)
 		}
 
 		// 如果是目录的话，需要检查目录下是否还有文件
-		if file.IsDict.Bool {
+		if file.IsDict.Bool && !recursive {
 			var count int64
 			if err := tx.Model(file).Where(&models.File{ParentId: fileId}).Count(&count).Error; err != nil {
 				return err
@@ -338,10 +620,27 @@ func (f *fileService) DeleteFile(username, fileId string) (*models.File, error)
 			}
 		}
 
-		// All is OK
-		return tx.Delete(file).Error
+		// 还没有上传完成的文件直接删除，其余的放入回收站
+		if file.FileStatus == models.READY {
+			return tx.Unscoped().Delete(file).Error
+		}
+
+		files, err := moveToTrash(tx, file)
+		if err != nil {
+			return err
+		}
+
+		for _, child := range files {
+			if child.UserName != username && child.UserName != "" {
+				return fileerr.ErrContainsOthersFiles
+			}
+		}
+
+		moves, err = f.trashObjects(tx, files)
+		return err
 
 	}); err != nil {
+		f.revertMoves(moves)
 		return nil, err
 	}
 
@@ -349,76 +648,1134 @@ func (f *fileService) DeleteFile(username, fileId string) (*models.File, error)
 
 }
 
-func (f *fileService) PreSaveFile(username string, file *models.File) (*models.File, error) {
-	return f.createFile(username, file, false)
-}
-
-func (f *fileService) CreateDictory(username, parentId, name string, permission models.Permission, password string) (*models.File, error) {
+// DeleteFileRecursive 彻底删除文件夹以及其中所有的文件，存储中的文件也会被删除，不会进入回收站。
+// 数据库中的记录会先被放入回收站并提交，然后再删除存储中的文件，删除成功后才彻底删除记录，
+// 删除失败的文件会留在回收站中，可以在回收站中重试或者等待自动清理。
+// 没有权限的文件以及包含这些文件的文件夹会被保留，它们和删除失败的文件都会记录在返回结果中
+func (f *fileService) DeleteFileRecursive(username, fileId string) (*models.DeleteReport, error) {
 
-	return f.createFile(username, &models.File{
-		ParentId:   parentId,
-		Name:       name,
-		Permission: permission,
-		Password:   password,
-	}, true)
+	if username == "" {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
 
-}
+	if fileId == "" {
+		return nil, fileerr.ErrFileNotFound
+	}
 
-func (f *fileService) createFile(username string, saveFile *models.File, isDir bool) (*models.File, error) {
+	report := &models.DeleteReport{
+		Failures: []*models.DeleteFailure{},
+	}
 
-	var file *models.File
+	// 按照从最深的节点到最上层节点的顺序保存需要删除的文件
+	deleting := []*models.File{}
 
 	if err := f.db.Transaction(func(tx *gorm.DB) error {
 
-		parentDir := ""
-
-		// 先检查是否存在同名文件夹
-		existFile := &models.File{
-			ParentId: saveFile.ParentId,
-			Name:     saveFile.Name,
-		}
-
-		var count int64
-		if err := tx.Model(existFile).Where(existFile).Count(&count).Error; err != nil {
+		file := &models.File{ID: fileId}
+		if err := tx.Where(file).First(file).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fileerr.ErrFileNotFound
+			}
 			return err
 		}
 
-		if count > 0 {
-			return fileerr.ErrFileExists
+		if file.UserName != username && file.UserName != "" {
+			return fileerr.ErrNotEnoughPermission
 		}
 
-		if saveFile.ParentId != "" {
-			// 检查父目录存不存在
-			parentFile := &models.File{
-				ID: saveFile.ParentId,
+		// 逐层查找所有的子孙节点，回收站中的文件也一起删除
+		files := []*models.File{file}
+		parentIds := []string{file.ID}
+		for file.IsDict.Bool && len(parentIds) > 0 {
+			children := []*models.File{}
+			if err := tx.Unscoped().Where("parent_id in ?", parentIds).Find(&children).Error; err != nil {
+				return err
 			}
 
-			if err := tx.Where(parentFile).First(parentFile).Error; err != nil {
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					return fileerr.ErrFileNotFound
+			parentIds = []string{}
+			for _, child := range children {
+				files = append(files, child)
+				if child.IsDict.Bool {
+					parentIds = append(parentIds, child.ID)
 				}
-				return err
 			}
+		}
 
-			// 是不是文件夹
-			if !parentFile.IsDict.Bool {
-				return fileerr.ErrNotDirectoy
-			}
+		// 有文件被保留时，它的所有上级文件夹也需要保留
+		kept := map[string]bool{}
+		deleteIds := []string{}
+		for i := len(files) - 1; i >= 0; i-- {
+			child := files[i]
 
-			// 是否有权限
-			if (username == "" && parentFile.Permission != models.PUBLICREAD) || (parentFile.Permission == models.MEREAD && parentFile.UserName != username) {
-				return fileerr.ErrNotEnoughPermission
+			if kept[child.ID] {
+				kept[child.ParentId] = true
+				continue
 			}
 
-			// 如果设置的权限小于父目录的权限，需要提升权限
-
-			if saveFile.Permission < parentFile.Permission {
-				saveFile.Permission = parentFile.Permission
-			}
-			if saveFile.Permission == models.PASSWORD && saveFile.Password == "" {
-				saveFile.Password = parentFile.Password
+			if child.UserName != username && child.UserName != "" {
+				report.Failures = append(report.Failures, &models.DeleteFailure{
+					AbsolutePath: child.AbsolutePath,
+					Reason:       fileerr.ErrNotEnoughPermission.Error(),
+				})
+				kept[child.ParentId] = true
+				continue
 			}
 
+			deleting = append(deleting, child)
+			deleteIds = append(deleteIds, child.ID)
+		}
+
+		if len(deleteIds) == 0 {
+			return nil
+		}
+
+		// 每个文件都作为回收站中单独的一项，删除失败时可以单独重试
+		now := time.Now()
+		for _, child := range deleting {
+			child.TrashId = child.ID
+			child.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+		}
+
+		return tx.Unscoped().Model(&models.File{}).Where("id in ?", deleteIds).Updates(map[string]interface{}{
+			"trash_id":   gorm.Expr("id"),
+			"deleted_at": now,
+		}).Error
+
+	}); err != nil {
+		return nil, err
+	}
+
+	// 记录已经提交，再删除存储中的文件，子孙节点总是在上级文件夹之前被处理
+	failed := map[string]bool{}
+	deletedIds := []string{}
+	for _, child := range deleting {
+
+		if failed[child.ID] {
+			failed[child.ParentId] = true
+			continue
+		}
+
+		if child.IsDict.Bool {
+			if err := f.removeDir(child.AbsolutePath); err != nil {
+				log.Printf("删除存储中的文件夹%s失败: %s", child.AbsolutePath, err)
+			}
+		} else {
+			inUse, err := objectInUse(f.db, child)
+			if err == nil && !inUse {
+				err = f.deleteObject(objectKey(child))
+			}
+			if err != nil {
+				report.Failures = append(report.Failures, &models.DeleteFailure{
+					AbsolutePath: child.AbsolutePath,
+					Reason:       err.Error(),
+				})
+				failed[child.ParentId] = true
+				continue
+			}
+		}
+
+		deletedIds = append(deletedIds, child.ID)
+	}
+
+	if len(deletedIds) > 0 {
+		if err := f.db.Unscoped().Where("id in ?", deletedIds).Delete(&models.File{}).Error; err != nil {
+			return report, err
+		}
+	}
+
+	report.Deleted = len(deletedIds)
+	return report, nil
+}
+
+// removeDir 删除存储中的空文件夹，驱动不支持时不做任何操作
+func (f *fileService) removeDir(key string) error {
+
+	if remover, ok := f.driver.(driver.DirRemover); ok {
+		if err := remover.RemoveDir(key); !errors.Is(err, fileerr.ErrUnSupportOperation) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteUserFiles 彻底删除用户的所有文件，包括回收站中的文件。
+// 其他用户的文件以及包含这些文件的文件夹会被保留并记录在返回结果中
+func (f *fileService) DeleteUserFiles(username string) (*models.DeleteReport, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	report := &models.DeleteReport{
+		Failures: []*models.DeleteFailure{},
+	}
+
+	// 父文件夹不属于这个用户的文件是最上层的文件，删除它们时会一起删除其中的文件
+	ownIds := f.db.Model(&models.File{}).Select("id").Where("user_name = ?", username)
+	topIds := []string{}
+	if err := f.db.Model(&models.File{}).Where("user_name = ? and (parent_id = '' or parent_id not in (?))", username, ownIds).
+		Pluck("id", &topIds).Error; err != nil {
+		return nil, err
+	}
+
+	for _, id := range topIds {
+		result, err := f.DeleteFileRecursive(username, id)
+		if err != nil {
+			// 可能已经随着其它文件夹一起被删除了
+			if errors.Is(err, fileerr.ErrFileNotFound) {
+				continue
+			}
+			return report, err
+		}
+		report.Deleted += result.Deleted
+		report.Failures = append(report.Failures, result.Failures...)
+	}
+
+	trashes := []*models.File{}
+	if err := f.db.Unscoped().Where("deleted_at is not null and trash_id = id and user_name = ?", username).Find(&trashes).Error; err != nil {
+		return report, err
+	}
+
+	for _, trash := range trashes {
+		var count int64
+		if err := f.db.Unscoped().Model(&models.File{}).Where("trash_id = ?", trash.ID).Count(&count).Error; err != nil {
+			return report, err
+		}
+
+		if _, err := f.PurgeFile(username, trash.ID); err != nil {
+			report.Failures = append(report.Failures, &models.DeleteFailure{
+				AbsolutePath: trash.AbsolutePath,
+				Reason:       err.Error(),
+			})
+			continue
+		}
+		report.Deleted += int(count)
+	}
+
+	return report, nil
+}
+
+// moveToTrash 将文件以及所有的子孙节点放入回收站，它们的TrashId都是被删除的顶层文件的ID，返回放入回收站的所有记录
+func moveToTrash(tx *gorm.DB, file *models.File) ([]*models.File, error) {
+
+	files := []*models.File{file}
+	ids := []string{file.ID}
+	parentIds := []string{file.ID}
+	for file.IsDict.Bool && len(parentIds) > 0 {
+		children := []*models.File{}
+		if err := tx.Where("parent_id in ?", parentIds).Find(&children).Error; err != nil {
+			return nil, err
+		}
+
+		parentIds = []string{}
+		for _, child := range children {
+			files = append(files, child)
+			ids = append(ids, child.ID)
+			if child.IsDict.Bool {
+				parentIds = append(parentIds, child.ID)
+			}
+		}
+	}
+
+	now := time.Now()
+	if err := tx.Model(&models.File{}).Where("id in ?", ids).Updates(map[string]interface{}{
+		"trash_id":   file.ID,
+		"deleted_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	for _, child := range files {
+		child.TrashId = file.ID
+		child.DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+	}
+
+	return files, nil
+}
+
+// trashDir 是存储中保存回收站文件的文件夹，位于每个挂载的存储的根目录下
+const trashDir = "/.trash"
+
+// trashKey 返回文件放入回收站后在存储中的位置，驱动不支持移动时返回空字符串，文件仍然保留在原来的位置
+func (f *fileService) trashKey(file *models.File) (string, error) {
+
+	d, key, err := driver.Resolve(f.driver, file.AbsolutePath)
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := d.(driver.Mover); !ok {
+		return "", nil
+	}
+
+	return strings.TrimSuffix(file.AbsolutePath, key) + trashDir + "/" + file.ID, nil
+}
+
+// isTrashKey 判断key是否在存储的回收站文件夹中
+func (f *fileService) isTrashKey(key string) bool {
+
+	_, key, err := driver.Resolve(f.driver, key)
+	if err != nil {
+		return false
+	}
+
+	return key == trashDir || strings.HasPrefix(key, trashDir+"/")
+}
+
+// trashObjects 把放入回收站的文件在存储中移动到回收站文件夹，之后上传到原来位置的文件不会覆盖它们。
+// 数据库的修改需要和files在同一个事务中，失败时已经移动的文件会被移回去；
+// 成功时返回移动过的文件，事务提交失败时需要通过revertMoves移回去
+func (f *fileService) trashObjects(tx *gorm.DB, files []*models.File) ([][2]string, error) {
+
+	moves := [][2]string{}
+	for _, file := range files {
+		if file.IsDict.Bool || file.FileStatus != models.SUCCESS {
+			continue
+		}
+
+		key, err := f.trashKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			continue
+		}
+
+		if err := tx.Unscoped().Model(file).Update("trash_key", key).Error; err != nil {
+			return nil, err
+		}
+		moves = append(moves, [2]string{file.AbsolutePath, key})
+	}
+
+	if err := f.moveObjects(moves); err != nil {
+		return nil, err
+	}
+
+	return moves, nil
+}
+
+// moveObjects 依次移动存储中的文件，中途失败时把已经移动的文件移回原来的位置
+func (f *fileService) moveObjects(moves [][2]string) error {
+
+	if len(moves) == 0 {
+		return nil
+	}
+
+	mover, ok := f.driver.(driver.Mover)
+	if !ok {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	for i, move := range moves {
+		if err := mover.Move(move[0], move[1]); err != nil {
+			f.revertMoves(moves[:i])
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revertMoves 把已经移动的文件移回原来的位置，失败时只记录日志
+func (f *fileService) revertMoves(moves [][2]string) {
+
+	mover, ok := f.driver.(driver.Mover)
+	if !ok {
+		return
+	}
+
+	for i := len(moves) - 1; i >= 0; i-- {
+		if err := mover.Move(moves[i][1], moves[i][0]); err != nil {
+			log.Printf("把存储中的文件%s移回%s失败: %s", moves[i][1], moves[i][0], err)
+		}
+	}
+}
+
+// checkWritable 检查是否可以在absolutePath写入新的文件(夹)。存储根目录下的回收站文件夹不能使用，
+// 回收站中还保存在原来位置的文件会被新写入的文件覆盖，也不允许写入
+func (f *fileService) checkWritable(tx *gorm.DB, absolutePath string) error {
+
+	if f.isTrashKey(absolutePath) {
+		return fileerr.ErrUnAllowFileName
+	}
+
+	var count int64
+	if err := tx.Unscoped().Model(&models.File{}).Where("deleted_at is not null and is_dict = ? and trash_key = '' and (absolute_path = ? or absolute_path like ? escape '!')",
+		false, absolutePath, likePrefix(absolutePath+"/")).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fileerr.ErrPathInTrash
+	}
+
+	return nil
+}
+
+// likePrefix 返回匹配以prefix开头的字符串的like表达式，需要和escape '!'一起使用
+func likePrefix(prefix string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(prefix) + "%"
+}
+
+// ListTrash 列出用户回收站中的文件，同一次删除的文件夹只显示顶层的文件夹
+func (f *fileService) ListTrash(username string, page, count int) (*models.PageResult, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrNeedLogin
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	if count < 1 || count > 50 {
+		count = 50
+	}
+
+	query := func() *gorm.DB {
+		return f.db.Unscoped().Model(&models.File{}).Where("deleted_at is not null and trash_id = id and user_name in ?", []string{username, ""})
+	}
+
+	files := []*models.File{}
+	if err := query().Order("deleted_at desc").Offset((page - 1) * count).Limit(count).Find(&files).Error; err != nil {
+		return nil, err
+	}
+
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.PageResult{
+		Total:     int(total),
+		Page:      page,
+		PageCount: count,
+		List:      files,
+	}, nil
+}
+
+// findTrash 查找回收站中由fileId删除操作放入的顶层文件
+func findTrash(tx *gorm.DB, username, fileId string) (*models.File, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	if fileId == "" {
+		return nil, fileerr.ErrFileNotFound
+	}
+
+	file := &models.File{}
+	if err := tx.Unscoped().Where("id = ? and trash_id = ? and deleted_at is not null", fileId, fileId).First(file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	if file.UserName != username && file.UserName != "" {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	return file, nil
+}
+
+// RestoreFile 将回收站中的文件(夹)还原到原来的位置
+func (f *fileService) RestoreFile(username, fileId string) (*models.File, error) {
+
+	var file *models.File
+	moves := [][2]string{}
+
+	if err := f.db.Transaction(func(tx *gorm.DB) error {
+
+		var err error
+		file, err = findTrash(tx, username, fileId)
+		if err != nil {
+			return err
+		}
+
+		if file.ParentId != "" {
+			var count int64
+			if err := tx.Model(&models.File{}).Where("id = ?", file.ParentId).Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return fileerr.ErrParentInTrash
+			}
+		}
+
+		var count int64
+		if err := tx.Model(&models.File{}).Where("parent_id = ? and name = ?", file.ParentId, file.Name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fileerr.ErrFileExists
+		}
+
+		// 保存在回收站文件夹中的文件需要移回原来的位置
+		trashed := []*models.File{}
+		if err := tx.Unscoped().Where("trash_id = ? and trash_key <> ''", file.ID).Find(&trashed).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&models.File{}).Where("trash_id = ?", file.ID).Updates(map[string]interface{}{
+			"trash_id":   "",
+			"deleted_at": nil,
+			"trash_key":  "",
+			"sync_gen":   time.Now().UnixNano(),
+		}).Error; err != nil {
+			return err
+		}
+
+		for _, child := range trashed {
+			moves = append(moves, [2]string{child.TrashKey, child.AbsolutePath})
+		}
+		if err := f.moveObjects(moves); err != nil {
+			moves = nil
+			return err
+		}
+
+		file.TrashId = ""
+		file.TrashKey = ""
+		file.DeletedAt = gorm.DeletedAt{}
+		return nil
+
+	}); err != nil {
+		f.revertMoves(moves)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// PurgeFile 彻底删除回收站中的文件(夹)，存储中的文件也会被删除
+func (f *fileService) PurgeFile(username, fileId string) (*models.File, error) {
+
+	file, err := findTrash(f.db, username, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	return file, f.purge(file)
+}
+
+// PurgeTrash 彻底删除在before之前放入回收站的文件，返回删除的数量
+func (f *fileService) PurgeTrash(before time.Time) (int, error) {
+
+	files := []*models.File{}
+	if err := f.db.Unscoped().Where("deleted_at < ? and trash_id = id", before).Find(&files).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var lastErr error
+	for _, file := range files {
+		if err := f.purge(file); err != nil {
+			lastErr = err
+			continue
+		}
+		purged++
+	}
+
+	return purged, lastErr
+}
+
+// PurgeUploading 彻底删除before之前创建但是一直没有上传完成的文件，存储中只上传了一部分的文件也会被删除，返回删除的数量。
+// 断点续传每次写入数据时都会通过TouchUploading更新文件的修改时间，因此不会删除正在上传的文件
+func (f *fileService) PurgeUploading(before time.Time) (int, error) {
+
+	// 正在分片上传的文件由分片上传的清理任务处理
+	files := []*models.File{}
+	if err := f.db.Where("file_status = ? and is_dict = ? and last_modify_time < ?", models.READY, false, before).
+		Where("id not in (?)", f.db.Model(&models.MultipartUpload{}).Select("file_id")).
+		Find(&files).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var lastErr error
+	for _, file := range files {
+		if err := f.purgeUploading(file); err != nil {
+			lastErr = err
+			continue
+		}
+		purged++
+	}
+
+	return purged, lastErr
+}
+
+func (f *fileService) purgeUploading(file *models.File) error {
+
+	// 先删除记录，避免删除存储中的文件时文件刚好被确认上传完成
+	result := f.db.Unscoped().Where("file_status = ?", models.READY).Delete(file)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	// 回收站中相同位置的文件仍然需要使用存储中的文件
+	var count int64
+	if err := f.db.Unscoped().Model(&models.File{}).Where("absolute_path = ? and deleted_at is not null and trash_key = ''", file.AbsolutePath).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if err := f.deleteObject(file.AbsolutePath); err != nil {
+		return fmt.Errorf("删除存储中的文件%s失败: %w", file.AbsolutePath, err)
+	}
+
+	return nil
+}
+
+func (f *fileService) purge(trash *models.File) error {
+
+	files := []*models.File{}
+	if err := f.db.Unscoped().Where("trash_id = ?", trash.ID).Find(&files).Error; err != nil {
+		return err
+	}
+
+	// 先删除存储中的文件，有文件删除失败时保留数据库中的记录，下次清理时重试
+	dirs := []*models.File{}
+	for _, file := range files {
+		if file.IsDict.Bool {
+			dirs = append(dirs, file)
+			continue
+		}
+
+		inUse, err := objectInUse(f.db, file)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			continue
+		}
+
+		if err := f.deleteObject(objectKey(file)); err != nil {
+			return fmt.Errorf("删除存储中的文件%s失败: %w", file.AbsolutePath, err)
+		}
+	}
+
+	// 从最深的文件夹开始删除，文件夹中还有其它文件时会被保留
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i].AbsolutePath) > len(dirs[j].AbsolutePath)
+	})
+	for _, dir := range dirs {
+		if err := f.removeDir(dir.AbsolutePath); err != nil {
+			log.Printf("删除存储中的文件夹%s失败: %s", dir.AbsolutePath, err)
+		}
+	}
+
+	return f.db.Unscoped().Where("trash_id = ?", trash.ID).Delete(&models.File{}).Error
+}
+
+// objectKey 返回文件在存储中的位置，回收站中的文件可能已经被移动到回收站文件夹中
+func objectKey(file *models.File) string {
+	if file.TrashKey != "" {
+		return file.TrashKey
+	}
+	return file.AbsolutePath
+}
+
+// objectInUse 回收站中仍然保存在原来位置的文件删除后又在相同的位置上传了文件时，存储中的文件已经属于新的文件
+func objectInUse(tx *gorm.DB, file *models.File) (bool, error) {
+
+	if !file.DeletedAt.Valid || file.TrashKey != "" {
+		return false, nil
+	}
+
+	var count int64
+	if err := tx.Model(&models.File{}).Where("absolute_path = ?", file.AbsolutePath).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// deleteObject 删除存储中的文件，驱动不支持在服务端删除时通过驱动签发的删除链接删除
+func (f *fileService) deleteObject(key string) error {
+
+	if deleter, ok := f.driver.(driver.Deleter); ok {
+		// 挂载了多个驱动时，具体的驱动不一定支持在服务端删除
+		if err := deleter.Delete(key); !errors.Is(err, fileerr.ErrUnSupportOperation) {
+			return err
+		}
+	}
+
+	deleteUrl, err := f.driver.PreDeleteUrl(key)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("DELETE", deleteUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("删除文件返回错误返回码%d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// unlockTokenExpire 解锁令牌的有效期
+const unlockTokenExpire = 2 * time.Hour
+
+// unlockTokenPrefix 用于区分解锁令牌和密码
+const unlockTokenPrefix string = "unlock."
+
+// HashFilePassword 计算文件(夹)密码的哈希，访问加密文件夹时每次都需要校验，因此使用默认的计算强度
+func HashFilePassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// signUnlockToken 令牌为 unlock.过期时间.签名，签名使用密码的哈希作为密钥，
+// 因此只能访问使用相同密码哈希的文件，修改密码后原来的令牌会失效
+func signUnlockToken(passwordHash string, expireAt int64) string {
+	mac := hmac.New(sha256.New, []byte(passwordHash))
+	mac.Write([]byte(strconv.FormatInt(expireAt, 10)))
+	return fmt.Sprintf("%s%d.%s", unlockTokenPrefix, expireAt, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// checkFilePassword 检查加密文件的密码，password可以是密码本身，也可以是UnlockFile返回的解锁令牌
+func checkFilePassword(file *models.File, password string) bool {
+
+	if file.Password == "" {
+		return true
+	}
+
+	if strings.HasPrefix(password, unlockTokenPrefix) {
+		parts := strings.SplitN(strings.TrimPrefix(password, unlockTokenPrefix), ".", 2)
+		if len(parts) == 2 {
+			expireAt, err := strconv.ParseInt(parts[0], 10, 64)
+			if err == nil && time.Now().Unix() < expireAt {
+				return hmac.Equal([]byte(signUnlockToken(file.Password, expireAt)), []byte(password))
+			}
+		}
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword([]byte(file.Password), []byte(password)) == nil
+}
+
+// inheritPermission 如果文件的权限小于父目录的权限，需要提升到父目录的权限
+func inheritPermission(file *models.File, parentFile *models.File) {
+	if file.Permission < parentFile.Permission {
+		file.Permission = parentFile.Permission
+	}
+	if file.Permission == models.PASSWORD && file.Password == "" {
+		file.Password = parentFile.Password
+	}
+}
+
+func (f *fileService) RenameFile(username, fileId, name string) (*models.File, error) {
+	return f.moveFile(username, fileId, nil, name)
+}
+
+// MoveFile 将文件(夹)移动到parentId目录下，name为空时保持原来的名字
+func (f *fileService) MoveFile(username, fileId, parentId, name string) (*models.File, error) {
+	return f.moveFile(username, fileId, &parentId, name)
+}
+
+func (f *fileService) moveFile(username, fileId string, parentId *string, name string) (*models.File, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	if fileId == "" {
+		return nil, fileerr.ErrFileNotFound
+	}
+
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return nil, fileerr.ErrUnAllowFileName
+	}
+
+	mover, ok := f.driver.(driver.Mover)
+	if !ok {
+		return nil, fileerr.ErrUnSupportOperation
+	}
+
+	var file *models.File = &models.File{ID: fileId}
+
+	if err := f.db.Transaction(func(tx *gorm.DB) error {
+
+		if err := tx.Where(file).First(file).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fileerr.ErrFileNotFound
+			}
+			return err
+		}
+
+		// 只能移动自己的
+		if file.UserName != username && file.UserName != "" {
+			return fileerr.ErrNotEnoughPermission
+		}
+
+		newParentId := file.ParentId
+		if parentId != nil {
+			newParentId = *parentId
+		}
+		newName := file.Name
+		if name != "" {
+			newName = name
+		}
+
+		if newParentId == file.ParentId && newName == file.Name {
+			return nil
+		}
+
+		parentFile := &models.File{
+			ID:         "",
+			Permission: models.PUBLICREAD,
+		}
+
+		if newParentId != "" {
+			parentFile.ID = newParentId
+			if err := tx.Where(parentFile).First(parentFile).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return fileerr.ErrFileNotFound
+				}
+				return err
+			}
+
+			if !parentFile.IsDict.Bool {
+				return fileerr.ErrNotDirectoy
+			}
+
+			if parentFile.Permission == models.MEREAD && parentFile.UserName != username {
+				return fileerr.ErrNotEnoughPermission
+			}
+
+			// 不能移动到自己的子目录中
+			if parentFile.ID == file.ID || strings.HasPrefix(parentFile.AbsolutePath, file.AbsolutePath+"/") {
+				return fileerr.ErrMoveToSubDirectory
+			}
+		}
+
+		// 检查是否存在同名文件
+		var count int64
+		if err := tx.Model(&models.File{}).Where("parent_id = ? and name = ?", newParentId, newName).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fileerr.ErrFileExists
+		}
+
+		// 先找出所有的子孙节点，回收站中的文件在存储中也会被一起移动。
+		// 和删除一样，文件夹中有其他用户的文件时不允许移动
+		descendants := []*models.File{}
+		parentIds := []string{file.ID}
+		for file.IsDict.Bool && len(parentIds) > 0 {
+			children := []*models.File{}
+			if err := tx.Unscoped().Where("parent_id in ?", parentIds).Find(&children).Error; err != nil {
+				return err
+			}
+
+			parentIds = []string{}
+			for _, child := range children {
+				if child.UserName != username && child.UserName != "" {
+					return fileerr.ErrContainsOthersFiles
+				}
+				descendants = append(descendants, child)
+				if child.IsDict.Bool {
+					parentIds = append(parentIds, child.ID)
+				}
+			}
+		}
+
+		oldPath := file.AbsolutePath
+		newPath := fmt.Sprintf("%s/%s", strings.TrimRight(parentFile.AbsolutePath, "/"), newName)
+		if err := f.checkWritable(tx, newPath); err != nil {
+			return err
+		}
+
+		syncGen := time.Now().UnixNano()
+		file.Name = newName
+		file.ParentId = newParentId
+		file.AbsolutePath = newPath
+		file.SyncGen = syncGen
+		inheritPermission(file, parentFile)
+		if err := tx.Select("name", "parent_id", "absolute_path", "permission", "password", "sync_gen").Updates(file).Error; err != nil {
+			return err
+		}
+
+		for _, child := range descendants {
+			child.AbsolutePath = newPath + strings.TrimPrefix(child.AbsolutePath, oldPath)
+			child.SyncGen = syncGen
+			inheritPermission(child, parentFile)
+			if err := tx.Unscoped().Select("absolute_path", "permission", "password", "sync_gen").Updates(child).Error; err != nil {
+				return err
+			}
+		}
+
+		// 最后移动存储中的文件，失败时回滚数据库的修改。存储的移动不是原子的，
+		// 例如S3会先复制所有的对象再逐个删除，中途失败时数据库仍然是原来的路径，
+		// 存储中的文件可能一部分在原路径、一部分在新路径，驱动需要保证重新执行同样的移动可以完成剩下的部分
+		return mover.Move(oldPath, newPath)
+
+	}); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// CopyFile 将文件(夹)复制到parentId目录下，name为空时保持原来的名字，复制出来的文件归当前用户所有
+func (f *fileService) CopyFile(username, fileId, parentId, name string) (*models.File, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	if fileId == "" {
+		return nil, fileerr.ErrFileNotFound
+	}
+
+	if strings.Contains(name, "/") || name == "." || name == ".." {
+		return nil, fileerr.ErrUnAllowFileName
+	}
+
+	copier, ok := f.driver.(driver.Copier)
+	if !ok {
+		return nil, fileerr.ErrUnSupportOperation
+	}
+
+	var newFile *models.File
+
+	if err := f.db.Transaction(func(tx *gorm.DB) error {
+
+		file := &models.File{ID: fileId}
+		if err := tx.Where(file).First(file).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fileerr.ErrFileNotFound
+			}
+			return err
+		}
+
+		if !canCopy(username, file) {
+			return fileerr.ErrNotEnoughPermission
+		}
+
+		parentFile := &models.File{
+			ID:         "",
+			Permission: models.PUBLICREAD,
+		}
+
+		if parentId != "" {
+			parentFile.ID = parentId
+			if err := tx.Where(parentFile).First(parentFile).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return fileerr.ErrFileNotFound
+				}
+				return err
+			}
+
+			if !parentFile.IsDict.Bool {
+				return fileerr.ErrNotDirectoy
+			}
+
+			if parentFile.Permission == models.MEREAD && parentFile.UserName != username {
+				return fileerr.ErrNotEnoughPermission
+			}
+
+			if parentFile.ID == file.ID || strings.HasPrefix(parentFile.AbsolutePath, file.AbsolutePath+"/") {
+				return fileerr.ErrMoveToSubDirectory
+			}
+		}
+
+		if name == "" {
+			name = file.Name
+		}
+
+		var count int64
+		if err := tx.Model(&models.File{}).Where("parent_id = ? and name = ?", parentFile.ID, name).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fileerr.ErrFileExists
+		}
+
+		copyOf := func(src *models.File, parent *models.File, name string) (*models.File, error) {
+			dst := &models.File{
+				ID:             uuid.NewString(),
+				UserName:       username,
+				Name:           name,
+				ParentId:       parent.ID,
+				AbsolutePath:   fmt.Sprintf("%s/%s", strings.TrimRight(parent.AbsolutePath, "/"), name),
+				IsDict:         src.IsDict,
+				FileType:       src.FileType,
+				FileSize:       src.FileSize,
+				Permission:     src.Permission,
+				FileStatus:     models.SUCCESS,
+				LastModifyTime: time.Now(),
+				Password:       src.Password,
+				SyncGen:        time.Now().UnixNano(),
+			}
+			inheritPermission(dst, parent)
+			return dst, tx.Create(dst).Error
+		}
+
+		var err error
+		newFile, err = copyOf(file, parentFile, name)
+		if err != nil {
+			return err
+		}
+		if err := f.checkWritable(tx, newFile.AbsolutePath); err != nil {
+			return err
+		}
+
+		// 存储中只复制有新记录的文件，没有权限读取的文件虽然也在同一个前缀下，但是不能被复制
+		objects := [][2]string{}
+		if !file.IsDict.Bool {
+			objects = append(objects, [2]string{file.AbsolutePath, newFile.AbsolutePath})
+		}
+
+		// 逐层复制所有的子孙节点，还没有上传完成的文件以及没有权限读取的文件(夹)不复制
+		parents := map[string]*models.File{file.ID: newFile}
+		for len(parents) > 0 {
+			parentIds := []string{}
+			for id := range parents {
+				parentIds = append(parentIds, id)
+			}
+
+			children := []*models.File{}
+			if err := tx.Where("parent_id in ?", parentIds).Find(&children).Error; err != nil {
+				return err
+			}
+
+			nextParents := map[string]*models.File{}
+			for _, child := range children {
+				if child.FileStatus != models.SUCCESS || !canCopy(username, child) {
+					continue
+				}
+				childCopy, err := copyOf(child, parents[child.ParentId], child.Name)
+				if err != nil {
+					return err
+				}
+				if child.IsDict.Bool {
+					nextParents[child.ID] = childCopy
+				} else {
+					objects = append(objects, [2]string{child.AbsolutePath, childCopy.AbsolutePath})
+				}
+			}
+			parents = nextParents
+		}
+
+		// 最后复制存储中的文件，失败时回滚数据库的修改，并删除已经复制出来的文件
+		for i, object := range objects {
+			if err := copier.Copy(object[0], object[1]); err != nil {
+				for _, copied := range objects[:i] {
+					if err := f.deleteObject(copied[1]); err != nil {
+						log.Printf("删除复制出来的文件%s失败: %s", copied[1], err)
+					}
+				}
+				return err
+			}
+		}
+
+		return nil
+
+	}); err != nil {
+		return nil, err
+	}
+
+	return newFile, nil
+}
+
+// canCopy 没有读权限的文件不允许复制，加密的文件只有所有者可以复制
+func canCopy(username string, file *models.File) bool {
+	return file.UserName == username || (file.Permission != models.PASSWORD && file.Permission != models.MEREAD)
+}
+
+func (f *fileService) PreSaveFile(username string, file *models.File) (*models.File, error) {
+	return f.createFile(username, file, false)
+}
+
+func (f *fileService) CreateDictory(username, parentId, name string, permission models.Permission, password string) (*models.File, error) {
+
+	// 数据库中只保存密码的哈希，子文件夹继承的也是哈希
+	if password != "" {
+		hash, err := HashFilePassword(password)
+		if err != nil {
+			return nil, err
+		}
+		password = hash
+	}
+
+	return f.createFile(username, &models.File{
+		ParentId:   parentId,
+		Name:       name,
+		Permission: permission,
+		Password:   password,
+	}, true)
+
+}
+
+func (f *fileService) createFile(username string, saveFile *models.File, isDir bool) (*models.File, error) {
+
+	var file *models.File
+
+	if err := f.db.Transaction(func(tx *gorm.DB) error {
+
+		parentDir := ""
+
+		// 先检查是否存在同名文件夹
+		existFile := &models.File{
+			ParentId: saveFile.ParentId,
+			Name:     saveFile.Name,
+		}
+
+		var count int64
+		if err := tx.Model(existFile).Where(existFile).Count(&count).Error; err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return fileerr.ErrFileExists
+		}
+
+		if saveFile.ParentId != "" {
+			// 检查父目录存不存在
+			parentFile := &models.File{
+				ID: saveFile.ParentId,
+			}
+
+			if err := tx.Where(parentFile).First(parentFile).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return fileerr.ErrFileNotFound
+				}
+				return err
+			}
+
+			// 是不是文件夹
+			if !parentFile.IsDict.Bool {
+				return fileerr.ErrNotDirectoy
+			}
+
+			// 是否有权限
+			if (username == "" && parentFile.Permission != models.PUBLICREAD) || (parentFile.Permission == models.MEREAD && parentFile.UserName != username) {
+				return fileerr.ErrNotEnoughPermission
+			}
+
+			// 如果设置的权限小于父目录的权限，需要提升权限
+			inheritPermission(saveFile, parentFile)
+
 			parentDir = parentFile.AbsolutePath
 		}
 
@@ -439,6 +1796,11 @@ func (f *fileService) createFile(username string, saveFile *models.File, isDir b
 			FileSize:       saveFile.FileSize,
 			FileType:       saveFile.FileType,
 			Password:       saveFile.Password,
+			SyncGen:        time.Now().UnixNano(),
+		}
+
+		if err := f.checkWritable(tx, file.AbsolutePath); err != nil {
+			return err
 		}
 
 		return tx.Create(file).Error
@@ -450,99 +1812,270 @@ func (f *fileService) createFile(username string, saveFile *models.File, isDir b
 	return file, nil
 }
 
-func recursiveCreateFile(tx *gorm.DB, username string, parentFile *models.File, file *driver.File) error {
-	if file.IsDir && len(file.Childrens) > 0 {
+// syncBatchSize 同步时每处理这么多个文件就提交一次事务，避免一个事务过大
+const syncBatchSize = 500
+
+// SyncProgress 用于在同步的过程中报告进度，path为当前正在处理的路径
+type SyncProgress func(result models.SyncResult, path string)
+
+// fileSyncer 按批次将存储中的文件同步到数据库中，每一批使用一个单独的事务。
+// 访问过的记录会被标记上本次同步的编号gen，允许往里面同步文件的文件夹标记为gen，其他的标记为-gen，
+// 这样不需要在内存中保存存储中所有的路径。gen是同步开始的时间，新建、上传、复制、移动以及还原的记录
+// 都会被标记为当时的时间，同步过程中写入的记录不会被当作已经不存在的文件删除
+type fileSyncer struct {
+	ctx      context.Context
+	db       *gorm.DB
+	tx       *gorm.DB
+	pending  int
+	username string
+	progress SyncProgress
+	result   models.SyncResult
+	gen      int64
+	root     *models.File
+	// 最近一次查找的父文件夹，同一个文件夹下的文件通常是连续遍历的
+	parent *models.File
+}
 
-		for _, subfile := range file.Childrens {
+func (s *fileSyncer) begin() error {
+	s.tx = s.db.Begin()
+	s.pending = 0
+	return s.tx.Error
+}
 
-			absolutePath := strings.TrimRight(subfile.AbsolutePath, "/")
-			// 先检查是否已经存在这个文件
+func (s *fileSyncer) commit() error {
+	err := s.tx.Commit().Error
+	s.tx = nil
+	return err
+}
 
-			existfile := &models.File{
-				AbsolutePath: absolutePath,
-			}
+func (s *fileSyncer) rollback() {
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+}
 
-			err := tx.Where(existfile).First(existfile).Error
+func (s *fileSyncer) report(current string) {
+	if s.progress != nil {
+		s.progress(s.result, current)
+	}
+}
 
-			if err != nil {
+// findParent 查找允许往里面同步文件的父文件夹，不允许时返回nil
+func (s *fileSyncer) findParent(parentPath string) (*models.File, error) {
 
-				if !errors.Is(err, gorm.ErrRecordNotFound) {
-					return err
-				}
+	if parentPath == s.root.AbsolutePath {
+		return s.root, nil
+	}
 
-				// 需要自行创建此文件
-				saveFile := &models.File{
-					ID:             uuid.NewString(),
-					UserName:       username,
-					Name:           subfile.Name,
-					ParentId:       parentFile.ID,
-					AbsolutePath:   absolutePath,
-					IsDict:         sql.NullBool{Valid: true, Bool: subfile.IsDir},
-					LastModifyTime: time.Now(),
-					FileStatus:     models.SUCCESS,
-					Permission:     parentFile.Permission,
-					FileSize:       subfile.Size,
-					FileType:       utils.FindMimetypeByExt(filepath.Ext(subfile.Name)),
-					Password:       parentFile.Password,
-				}
+	if s.parent == nil || s.parent.AbsolutePath != parentPath {
+		parent := &models.File{}
+		if err := s.tx.Where("absolute_path = ?", parentPath).First(parent).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		s.parent = parent
+	}
 
-				// if !subfile.IsDir {
-				// 	// 不是文件夹，需要从文件名来推断文件类型
-				// }
+	// 编号更大说明被之后开始的同步访问过，同样是允许的
+	if !s.parent.IsDict.Bool || s.parent.SyncGen < s.gen {
+		return nil, nil
+	}
 
-				err := tx.Save(saveFile).Error
-				if err != nil {
-					return err
-				}
+	return s.parent, nil
+}
 
-				existfile = saveFile
+// syncEntry 同步存储中的一个文件，它的父文件夹必须已经先同步过
+func (s *fileSyncer) syncEntry(subfile *driver.File) error {
 
-			}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
 
-			// 不允许往别人的私人目录以及加密目录中塞文件
-			if subfile.IsDir && (username == existfile.UserName || existfile.Permission == models.PUBLICREAD) {
-				err = recursiveCreateFile(tx, username, existfile, subfile)
-				if err != nil {
-					return err
-				}
-			}
+	if s.pending >= syncBatchSize {
+		if err := s.commit(); err != nil {
+			return err
+		}
+		if err := s.begin(); err != nil {
+			return err
 		}
 	}
-	return nil
-}
+	s.pending++
+
+	absolutePath := strings.TrimRight(subfile.AbsolutePath, "/")
+	s.result.Scanned++
+	defer s.report(absolutePath)
+
+	tx := s.tx
 
-func (f *fileService) SyncFiles(username string, key string) error {
-	file, err := f.driver.WalkDir(key)
+	parentFile, err := s.findParent(path.Dir(absolutePath))
 	if err != nil {
 		return err
 	}
+	if parentFile == nil {
+		// 父文件夹不允许同步，只标记为存在
+		return tx.Model(&models.File{}).Where("absolute_path = ?", absolutePath).Update("sync_gen", -s.gen).Error
+	}
+
+	// 先检查是否已经存在这个文件
+	existfile := &models.File{
+		AbsolutePath: absolutePath,
+	}
+
+	err = tx.Where(existfile).First(existfile).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		// 回收站中的文件在清理之前仍然保留在存储中，不需要同步
+		var count int64
+		if err := tx.Unscoped().Model(&models.File{}).Where("absolute_path = ?", absolutePath).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
 
-	err = f.db.Transaction(func(tx *gorm.DB) error {
+		lastModifyTime := subfile.ModTime
+		if lastModifyTime.IsZero() {
+			lastModifyTime = time.Now()
+		}
 
-		// 开始数据导入
-		absolutePath := strings.TrimRight(file.AbsolutePath, "/")
+		// 需要自行创建此文件
+		saveFile := &models.File{
+			ID:             uuid.NewString(),
+			UserName:       s.username,
+			Name:           subfile.Name,
+			ParentId:       parentFile.ID,
+			AbsolutePath:   absolutePath,
+			IsDict:         sql.NullBool{Valid: true, Bool: subfile.IsDir},
+			LastModifyTime: lastModifyTime,
+			FileStatus:     models.SUCCESS,
+			Permission:     parentFile.Permission,
+			FileSize:       subfile.Size,
+			FileType:       utils.FindMimetypeByExt(filepath.Ext(subfile.Name)),
+			Password:       parentFile.Password,
+			SyncGen:        s.gen,
+		}
 
-		existfile := &models.File{
-			AbsolutePath: absolutePath,
+		if err := tx.Save(saveFile).Error; err != nil {
+			return err
 		}
+		s.result.Added++
 
-		if absolutePath != "" {
-			err := tx.Where(existfile).First(existfile).Error
-			if err != nil {
-				if errors.Is(err, gorm.ErrRecordNotFound) {
-					return fileerr.ErrFileNotFound
-				}
+		return nil
+	}
+
+	if !subfile.IsDir && !existfile.IsDict.Bool && existfile.FileStatus == models.SUCCESS {
+		// 存储中的文件被修改过
+		if existfile.FileSize != subfile.Size || subfile.ModTime.After(existfile.LastModifyTime) {
+			existfile.FileSize = subfile.Size
+			if !subfile.ModTime.IsZero() {
+				existfile.LastModifyTime = subfile.ModTime
+			}
+			if err := tx.Select("file_size", "last_modify_time").Updates(existfile).Error; err != nil {
 				return err
 			}
-		} else {
-			existfile.Permission = models.PUBLICREAD
-			existfile.ID = ""
-			existfile.Password = ""
+			s.result.Updated++
 		}
+	}
 
-		return recursiveCreateFile(tx, username, existfile, file)
-	})
+	// 不允许往别人的私人目录以及加密目录中塞文件
+	gen := s.gen
+	if subfile.IsDir && s.username != existfile.UserName && existfile.Permission != models.PUBLICREAD {
+		gen = -s.gen
+	}
 
-	return err
+	return tx.Model(existfile).Update("sync_gen", gen).Error
+}
+
+// removeVanished 分批删除本次同步中没有访问到的文件记录，文件夹可能只存在于数据库中，因此不会被删除
+func (s *fileSyncer) removeVanished() error {
+
+	prefix := likePrefix(strings.TrimRight(s.root.AbsolutePath, "/") + "/")
+
+	for {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+
+		// 编号在(-gen, gen)之外的记录被本次或者之后开始的同步访问过；还没有上传完成的文件不处理
+		removeIds := []string{}
+		if err := s.db.Model(&models.File{}).Where("is_dict = ? and file_status = ? and absolute_path like ? escape '!' and sync_gen > ? and sync_gen < ?",
+			false, models.SUCCESS, prefix, -s.gen, s.gen).Limit(syncBatchSize).Pluck("id", &removeIds).Error; err != nil {
+			return err
+		}
+
+		if len(removeIds) == 0 {
+			return nil
+		}
+
+		if err := s.db.Unscoped().Where("id in ?", removeIds).Delete(&models.File{}).Error; err != nil {
+			return err
+		}
+		s.result.Removed += len(removeIds)
+		s.report("")
+	}
+}
+
+// SyncFiles 将存储中key目录下的文件与数据库中的记录进行对比，添加新的文件，更新被修改过的文件，删除已经不存在的文件
+// 数据按批次提交，取消或者出错时已经提交的部分会保留下来，再次同步即可继续
+func (f *fileService) SyncFiles(ctx context.Context, username string, key string, progress SyncProgress) (*models.SyncResult, error) {
+
+	absolutePath := utils.ParsePath(key)
+	root := &models.File{
+		AbsolutePath: absolutePath,
+	}
+
+	if absolutePath != "/" {
+		if err := f.db.Where(root).First(root).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fileerr.ErrFileNotFound
+			}
+			return nil, err
+		}
+	} else {
+		root.Permission = models.PUBLICREAD
+		root.ID = ""
+		root.Password = ""
+	}
+
+	syncer := &fileSyncer{
+		ctx:      ctx,
+		db:       f.db,
+		username: username,
+		progress: progress,
+		gen:      time.Now().UnixNano(),
+		root:     root,
+	}
+
+	if err := syncer.begin(); err != nil {
+		return nil, err
+	}
+	defer syncer.rollback()
+
+	// 边遍历存储边导入，不需要先在内存中构建出完整的文件树
+	if err := f.driver.Walk(key, func(file *driver.File) error {
+		// 回收站文件夹中的文件不需要同步
+		if f.isTrashKey(file.AbsolutePath) {
+			return nil
+		}
+		return syncer.syncEntry(file)
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := syncer.commit(); err != nil {
+		return nil, err
+	}
+
+	// 只有完整地遍历了存储之后才能判断哪些文件已经不存在了
+	if err := syncer.removeVanished(); err != nil {
+		return nil, err
+	}
 
+	return &syncer.result, nil
 }
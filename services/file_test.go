@@ -0,0 +1,553 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lixiaofei123/nextlist/configs"
+	"github.com/lixiaofei123/nextlist/database"
+	"github.com/lixiaofei123/nextlist/driver"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+	"gorm.io/gorm"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+
+	db, err := database.Open(&configs.DataBase{
+		Type:   database.SQLITE,
+		Sqlite: configs.Sqlite{Path: filepath.Join(t.TempDir(), "nextlist.db")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func newTestDriver(t *testing.T) driver.Driver {
+
+	sdriver, err := driver.GetDriver("file", map[string]interface{}{
+		"path": t.TempDir(),
+		"key":  "nextlist",
+		"host": "http://127.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sdriver
+}
+
+func newTestFileService(t *testing.T) FileService {
+	return NewFileService(newTestDB(t), newTestDriver(t))
+}
+
+func Test_CountFiles(t *testing.T) {
+
+	fileSrv := newTestFileService(t)
+
+	dir, err := fileSrv.CreateDictory("admin", "", "docs", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file, err := fileSrv.PreSaveFile("admin", &models.File{
+			ParentId: dir.ID,
+			Name:     name,
+			FileType: "text",
+			FileSize: 3,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileSrv.FinishUpload("admin", file.ID, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := fileSrv.CountFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if results["text"] != 2 || results["totalSize"] != 6 || len(results) != 2 {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
+
+func Test_UnlockFile(t *testing.T) {
+
+	fileSrv := newTestFileService(t)
+
+	dir, err := fileSrv.CreateDictory("admin", "", "private", models.PASSWORD, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.Password == "secret" {
+		t.Fatal("password should be hashed")
+	}
+
+	// 子文件夹继承父文件夹的密码
+	sub, err := fileSrv.CreateDictory("admin", dir.ID, "sub", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fileSrv.FindChildFiles("", dir.ID, "wrong", 1, 50); !errors.Is(err, fileerr.ErrPasswordIsWrong) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := fileSrv.FindChildFiles("", dir.ID, "secret", 1, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fileSrv.UnlockFile(dir.ID, "wrong"); !errors.Is(err, fileerr.ErrPasswordIsWrong) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	token, err := fileSrv.UnlockFile(dir.ID, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fileSrv.FindById("", token.Token, sub.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fileSrv.FindById("", token.Token+"0", sub.ID); !errors.Is(err, fileerr.ErrPasswordIsWrong) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func Test_MoveFileWithOthersFiles(t *testing.T) {
+
+	fileSrv := newTestFileService(t)
+
+	dir, err := fileSrv.CreateDictory("alice", "", "docs", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSrv.CreateDictory("bobby", dir.ID, "bobby", models.MEREAD, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := fileSrv.RenameFile("alice", dir.ID, "renamed"); !errors.Is(err, fileerr.ErrContainsOthersFiles) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	child, err := fileSrv.ListFilesByPath("bobby", "/docs", "", 1, 10)
+	if err != nil || child.Total != 1 {
+		t.Fatalf("unexpected children %+v %v", child, err)
+	}
+}
+
+func Test_CopySkipsUnreadableFiles(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	fileSrv := NewFileService(newTestDB(t), sdriver)
+
+	upload := func(username, parentId, name string, permission models.Permission) *models.File {
+		file, err := fileSrv.PreSaveFile(username, &models.File{ParentId: parentId, Name: name, Permission: permission})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sdriver.(driver.Putter).Put(file.AbsolutePath, strings.NewReader("abc"), 3); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileSrv.FinishUpload(username, file.ID, 3); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	dir, err := fileSrv.CreateDictory("alice", "", "docs", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload("alice", dir.ID, "public.txt", models.PUBLICREAD)
+	upload("alice", dir.ID, "private.txt", models.MEREAD)
+
+	copied, err := fileSrv.CopyFile("bobby", dir.ID, "", "copied")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := fileSrv.FindChildFiles("bobby", copied.ID, "", 1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := result.List.([]*models.File)
+	if len(files) != 1 || files[0].Name != "public.txt" || files[0].UserName != "bobby" {
+		t.Fatalf("unexpected files %+v", files)
+	}
+
+	stater := sdriver.(driver.Stater)
+	if _, err := stater.Stat("/copied/public.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stater.Stat("/copied/private.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("private file should not be copied %v", err)
+	}
+}
+
+func Test_DeleteFileRecursive(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	fileSrv := NewFileService(newTestDB(t), sdriver)
+
+	dir, err := fileSrv.CreateDictory("alice", "", "docs", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := fileSrv.CreateDictory("alice", dir.ID, "sub", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := fileSrv.PreSaveFile("alice", &models.File{ParentId: sub.ID, Name: "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sdriver.(driver.Putter).Put(file.AbsolutePath, strings.NewReader("abc"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSrv.FinishUpload("alice", file.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := fileSrv.DeleteFileRecursive("alice", dir.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 3 || len(report.Failures) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// 存储中的文件夹也会被删除
+	if _, err := sdriver.(driver.Stater).Stat("/docs"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("directory should be removed %v", err)
+	}
+
+	trash, err := fileSrv.ListTrash("alice", 1, 10)
+	if err != nil || trash.Total != 0 {
+		t.Fatalf("unexpected trash %+v %v", trash, err)
+	}
+}
+
+func Test_SyncFiles(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	db := newTestDB(t)
+	fileSrv := NewFileService(db, sdriver)
+
+	if _, err := fileSrv.CreateDictory("bob", "", "secret", models.MEREAD, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"/docs/a.txt", "/docs/b.txt", "/secret/x.txt"} {
+		if err := sdriver.(driver.Putter).Put(key, strings.NewReader("abc"), 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := fileSrv.SyncFiles(context.Background(), "alice", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 别人的私人目录中的文件不会被同步
+	if result.Added != 3 || result.Removed != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	if err := sdriver.(driver.Deleter).Delete("/docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = fileSrv.SyncFiles(context.Background(), "alice", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 0 || result.Removed != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	var count int64
+	if err := db.Model(&models.File{}).Where("absolute_path in ?", []string{"/docs", "/docs/a.txt", "/secret"}).Count(&count).Error; err != nil || count != 3 {
+		t.Fatalf("unexpected files %d %v", count, err)
+	}
+}
+
+// walkHookDriver 在开始遍历存储之前执行before，模拟同步过程中用户的操作
+type walkHookDriver struct {
+	driver.Driver
+	before func()
+}
+
+func (d *walkHookDriver) Walk(key string, fn driver.WalkFunc) error {
+	d.before()
+	return d.Driver.Walk(key, fn)
+}
+
+func Test_UploadDuringSync(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	hook := &walkHookDriver{Driver: sdriver}
+	fileSrv := NewFileService(newTestDB(t), hook)
+
+	if err := sdriver.(driver.Putter).Put("/a.txt", strings.NewReader("abc"), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同步开始之后上传的文件，存储中还没有这个文件
+	var uploaded *models.File
+	hook.before = func() {
+		file, err := fileSrv.PreSaveFile("alice", &models.File{Name: "b.txt"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uploaded, err = fileSrv.FinishUpload("alice", file.ID, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := fileSrv.SyncFiles(context.Background(), "alice", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 1 || result.Removed != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if _, err := fileSrv.FindByPath(uploaded.AbsolutePath); err != nil {
+		t.Fatalf("uploaded file should be kept %v", err)
+	}
+
+	// 之后开始的同步会删除存储中不存在的文件
+	hook.before = func() {}
+	result, err = fileSrv.SyncFiles(context.Background(), "alice", "/", nil)
+	if err != nil || result.Removed != 1 {
+		t.Fatalf("unexpected result %+v %v", result, err)
+	}
+}
+
+func Test_PurgeUploading(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	db := newTestDB(t)
+	fileSrv := NewFileService(db, sdriver)
+
+	// 回收站中的文件原来的位置被没有上传完成的文件使用
+	shared, err := fileSrv.PreSaveFile("alice", &models.File{Name: "shared.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sdriver.(driver.Putter).Put(shared.AbsolutePath, strings.NewReader("abc"), 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSrv.FinishUpload("alice", shared.ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := fileSrv.DeleteFile("alice", shared.ID)
+	if err != nil || trashed.TrashKey == "" {
+		t.Fatalf("unexpected trash %+v %v", trashed, err)
+	}
+
+	files := map[string]*models.File{}
+	for _, name := range []string{"old.txt", "shared.txt", "touched.txt", "new.txt"} {
+		file, err := fileSrv.PreSaveFile("alice", &models.File{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = file
+	}
+	if err := sdriver.(driver.Putter).Put("/old.txt", strings.NewReader("ab"), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := db.Model(&models.File{}).Where("id in ?", []string{files["old.txt"].ID, files["shared.txt"].ID, files["touched.txt"].ID}).
+		Update("last_modify_time", old).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 正在断点续传的文件会更新修改时间
+	if err := fileSrv.TouchUploading("alice", files["touched.txt"].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := fileSrv.PurgeUploading(time.Now().Add(-24 * time.Hour))
+	if err != nil || count != 2 {
+		t.Fatalf("unexpected result %d %v", count, err)
+	}
+
+	stater := sdriver.(driver.Stater)
+	if _, err := stater.Stat("/old.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("old object should be removed %v", err)
+	}
+	if _, err := stater.Stat(trashed.TrashKey); err != nil {
+		t.Fatalf("object in trash should be kept %v", err)
+	}
+
+	for _, name := range []string{"touched.txt", "new.txt"} {
+		if _, err := fileSrv.FindUploadingFile("alice", files[name].ID); err != nil {
+			t.Fatalf("%s should be kept %v", name, err)
+		}
+	}
+}
+
+func uploadTestFile(t *testing.T, fileSrv FileService, sdriver driver.Driver, name, data string) *models.File {
+
+	file, err := fileSrv.PreSaveFile("alice", &models.File{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sdriver.(driver.Putter).Put(file.AbsolutePath, strings.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if file, err = fileSrv.FinishUpload("alice", file.ID, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func objectSize(t *testing.T, sdriver driver.Driver, key string) int64 {
+
+	file, err := sdriver.(driver.Stater).Stat(key)
+	if err != nil {
+		t.Fatalf("stat %s failed %v", key, err)
+	}
+
+	return file.Size
+}
+
+func Test_RestoreAndPurgeFile(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	fileSrv := NewFileService(newTestDB(t), sdriver)
+
+	old := uploadTestFile(t, fileSrv, sdriver, "a.txt", "old")
+	trashed, err := fileSrv.DeleteFile("alice", old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 放入回收站的文件被移动到回收站文件夹中，原来的位置可以上传新的文件
+	if trashed.TrashKey == "" || objectSize(t, sdriver, trashed.TrashKey) != 3 {
+		t.Fatalf("unexpected trash %+v", trashed)
+	}
+	current := uploadTestFile(t, fileSrv, sdriver, "a.txt", "newer")
+
+	if _, err := fileSrv.RestoreFile("alice", old.ID); !errors.Is(err, fileerr.ErrFileExists) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if objectSize(t, sdriver, "/a.txt") != 5 || objectSize(t, sdriver, trashed.TrashKey) != 3 {
+		t.Fatal("objects should not be changed")
+	}
+
+	if _, err := fileSrv.DeleteFile("alice", current.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileSrv.RestoreFile("bob", old.ID); !errors.Is(err, fileerr.ErrNotEnoughPermission) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	restored, err := fileSrv.RestoreFile("alice", old.ID)
+	if err != nil || restored.TrashKey != "" {
+		t.Fatalf("unexpected restore %+v %v", restored, err)
+	}
+	if objectSize(t, sdriver, "/a.txt") != 3 {
+		t.Fatal("restored object should be moved back")
+	}
+
+	// 彻底删除回收站中的文件不会影响已经还原的文件
+	purged, err := fileSrv.PurgeFile("alice", current.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sdriver.(driver.Stater).Stat(purged.TrashKey); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("purged object should be removed %v", err)
+	}
+	if objectSize(t, sdriver, "/a.txt") != 3 {
+		t.Fatal("restored object should be kept")
+	}
+	if _, err := fileSrv.RestoreFile("alice", current.ID); !errors.Is(err, fileerr.ErrFileNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func Test_PurgeTrash(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	db := newTestDB(t)
+	fileSrv := NewFileService(db, sdriver)
+
+	trashes := map[string]*models.File{}
+	for _, name := range []string{"old.txt", "new.txt"} {
+		file := uploadTestFile(t, fileSrv, sdriver, name, "abc")
+		trash, err := fileSrv.DeleteFile("alice", file.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trashes[name] = trash
+	}
+
+	if err := db.Unscoped().Model(&models.File{}).Where("id = ?", trashes["old.txt"].ID).
+		Update("deleted_at", time.Now().Add(-48*time.Hour)).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// 只清理超过保留时间的文件
+	count, err := fileSrv.PurgeTrash(time.Now().Add(-24 * time.Hour))
+	if err != nil || count != 1 {
+		t.Fatalf("unexpected result %d %v", count, err)
+	}
+
+	if _, err := sdriver.(driver.Stater).Stat(trashes["old.txt"].TrashKey); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expired object should be removed %v", err)
+	}
+	if objectSize(t, sdriver, trashes["new.txt"].TrashKey) != 3 {
+		t.Fatal("object in retention should be kept")
+	}
+
+	result, err := fileSrv.ListTrash("alice", 1, 10)
+	if err != nil || result.Total != 1 || result.List.([]*models.File)[0].ID != trashes["new.txt"].ID {
+		t.Fatalf("unexpected trash %+v %v", result, err)
+	}
+}
+
+// staticDriver 只提供驱动的基本功能，不能在存储中移动文件
+type staticDriver struct {
+	driver.Driver
+}
+
+func Test_TrashWithoutMover(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	fileSrv := NewFileService(newTestDB(t), &staticDriver{Driver: sdriver})
+
+	file := uploadTestFile(t, fileSrv, sdriver, "a.txt", "abc")
+	trashed, err := fileSrv.DeleteFile("alice", file.ID)
+	if err != nil || trashed.TrashKey != "" {
+		t.Fatalf("unexpected trash %+v %v", trashed, err)
+	}
+
+	// 回收站中的文件仍然在原来的位置，不能在这里写入新的文件
+	if _, err := fileSrv.PreSaveFile("alice", &models.File{Name: "a.txt"}); !errors.Is(err, fileerr.ErrPathInTrash) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := fileSrv.RestoreFile("alice", file.ID); err != nil {
+		t.Fatal(err)
+	}
+	if objectSize(t, sdriver, "/a.txt") != 3 {
+		t.Fatal("object should be kept")
+	}
+}
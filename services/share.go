@@ -0,0 +1,277 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type ShareService interface {
+	CreateShare(username, fileId string, expireAt *time.Time, maxDownloads int, password string) (*models.Share, error)
+
+	ListShares(username string, page, count int) (*models.PageResult, error)
+
+	DeleteShare(username, shareId string) (*models.Share, error)
+
+	FindShare(shareId, password string) (*models.Share, error)
+
+	ListShareFiles(shareId, password, fileId string, page, count int) (*models.PageResult, error)
+
+	DownloadShareFile(shareId, password, fileId string) (*models.File, error)
+}
+
+func NewShareService(db *gorm.DB, fileSrv FileService) ShareService {
+	return &shareService{
+		db:      db,
+		fileSrv: fileSrv,
+	}
+}
+
+type shareService struct {
+	db      *gorm.DB
+	fileSrv FileService
+}
+
+// CreateShare 为自己的文件(夹)创建分享链接，分享的访问权限与文件夹本身的权限无关
+func (s *shareService) CreateShare(username, fileId string, expireAt *time.Time, maxDownloads int, password string) (*models.Share, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrNeedLogin
+	}
+
+	if fileId == "" {
+		return nil, fileerr.ErrFileNotFound
+	}
+
+	file := &models.File{ID: fileId}
+	if err := s.db.Where(file).First(file).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	if file.UserName != username {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	if expireAt != nil && expireAt.Before(time.Now()) {
+		return nil, fileerr.ErrShareExpireInPast
+	}
+
+	if maxDownloads < 0 {
+		maxDownloads = 0
+	}
+
+	// 和加密目录一样，数据库中只保存密码的哈希
+	hasPassword := password != ""
+	if hasPassword {
+		hash, err := HashFilePassword(password)
+		if err != nil {
+			return nil, err
+		}
+		password = hash
+	}
+
+	share := &models.Share{
+		ID:           uuid.NewString(),
+		UserName:     username,
+		FileId:       file.ID,
+		File:         file,
+		ExpireAt:     expireAt,
+		MaxDownloads: maxDownloads,
+		Password:     password,
+		HasPassword:  hasPassword,
+		CreatedAt:    time.Now(),
+	}
+
+	if err := s.db.Create(share).Error; err != nil {
+		return nil, err
+	}
+
+	return share, nil
+}
+
+func (s *shareService) ListShares(username string, page, count int) (*models.PageResult, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrNeedLogin
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	if count < 1 || count > 50 {
+		count = 50
+	}
+
+	shares := []*models.Share{}
+	if err := s.db.Where("user_name = ?", username).Order("created_at desc").Offset((page - 1) * count).Limit(count).Find(&shares).Error; err != nil {
+		return nil, err
+	}
+
+	fileIds := []string{}
+	for _, share := range shares {
+		fileIds = append(fileIds, share.FileId)
+	}
+
+	files := []*models.File{}
+	if len(fileIds) > 0 {
+		if err := s.db.Where("id in ?", fileIds).Find(&files).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	fileMap := map[string]*models.File{}
+	for _, file := range files {
+		fileMap[file.ID] = file
+	}
+
+	for _, share := range shares {
+		share.File = fileMap[share.FileId]
+		share.HasPassword = share.Password != ""
+	}
+
+	var total int64
+	if err := s.db.Model(&models.Share{}).Where("user_name = ?", username).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.PageResult{
+		Total:     int(total),
+		Page:      page,
+		PageCount: count,
+		List:      shares,
+	}, nil
+}
+
+// DeleteShare 取消分享，只有创建者可以取消
+func (s *shareService) DeleteShare(username, shareId string) (*models.Share, error) {
+
+	share, err := s.findShare(shareId)
+	if err != nil {
+		return nil, err
+	}
+
+	if share.UserName != username {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	if err := s.db.Delete(share).Error; err != nil {
+		return nil, err
+	}
+
+	return share, nil
+}
+
+func (s *shareService) findShare(shareId string) (*models.Share, error) {
+
+	if shareId == "" {
+		return nil, fileerr.ErrShareNotFound
+	}
+
+	share := &models.Share{ID: shareId}
+	if err := s.db.Where(share).First(share).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrShareNotFound
+		}
+		return nil, err
+	}
+
+	share.HasPassword = share.Password != ""
+
+	return share, nil
+}
+
+// checkShare 检查分享是否过期以及访问密码是否正确
+func (s *shareService) checkShare(shareId, password string) (*models.Share, error) {
+
+	share, err := s.findShare(shareId)
+	if err != nil {
+		return nil, err
+	}
+
+	if share.ExpireAt != nil && share.ExpireAt.Before(time.Now()) {
+		return nil, fileerr.ErrShareExpired
+	}
+
+	if share.Password != "" && bcrypt.CompareHashAndPassword([]byte(share.Password), []byte(password)) != nil {
+		return nil, fileerr.ErrPasswordIsWrong
+	}
+
+	return share, nil
+}
+
+// FindShare 返回分享的信息，不包含下载链接，下载需要通过DownloadShareFile进行计数
+func (s *shareService) FindShare(shareId, password string) (*models.Share, error) {
+
+	share, err := s.checkShare(shareId, password)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.fileSrv.FindSharedFile(share.FileId, "")
+	if err != nil {
+		return nil, err
+	}
+	file.DownloadUrls = nil
+	share.File = file
+
+	return share, nil
+}
+
+func (s *shareService) ListShareFiles(shareId, password, fileId string, page, count int) (*models.PageResult, error) {
+
+	share, err := s.checkShare(shareId, password)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.fileSrv.FindSharedChildFiles(share.FileId, fileId, page, count)
+	if err != nil {
+		return nil, err
+	}
+
+	if files, ok := result.List.([]*models.File); ok {
+		for _, file := range files {
+			file.DownloadUrls = nil
+		}
+	}
+
+	return result, nil
+}
+
+// DownloadShareFile 返回分享中文件的下载链接，每次调用都会增加一次下载次数
+func (s *shareService) DownloadShareFile(shareId, password, fileId string) (*models.File, error) {
+
+	share, err := s.checkShare(shareId, password)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.fileSrv.FindSharedFile(share.FileId, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.IsDict.Bool {
+		return nil, fileerr.ErrIsDirectory
+	}
+
+	result := s.db.Model(&models.Share{}).Where("id = ? and (max_downloads = 0 or downloads < max_downloads)", share.ID).
+		Update("downloads", gorm.Expr("downloads + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fileerr.ErrShareDownloadLimit
+	}
+
+	return file, nil
+}
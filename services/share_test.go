@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+)
+
+func Test_CreateShare(t *testing.T) {
+
+	db := newTestDB(t)
+	fileSrv := NewFileService(db, newTestDriver(t))
+	shareSrv := NewShareService(db, fileSrv)
+
+	file, err := fileSrv.CreateDictory("alice", "", "docs", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只能分享自己的文件
+	if _, err := shareSrv.CreateShare("bob", file.ID, nil, 0, ""); !errors.Is(err, fileerr.ErrNotEnoughPermission) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if _, err := shareSrv.CreateShare("alice", file.ID, &past, 0, ""); !errors.Is(err, fileerr.ErrShareExpireInPast) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// 数据库中只保存密码的哈希
+	share, err := shareSrv.CreateShare("alice", file.ID, nil, 0, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &models.Share{}
+	if err := db.Where("id = ?", share.ID).First(saved).Error; err != nil || saved.Password == "" || saved.Password == "secret" {
+		t.Fatalf("unexpected password %s %v", saved.Password, err)
+	}
+}
+
+func Test_DownloadShareFile(t *testing.T) {
+
+	sdriver := newTestDriver(t)
+	db := newTestDB(t)
+	fileSrv := NewFileService(db, sdriver)
+	shareSrv := NewShareService(db, fileSrv)
+
+	file := uploadTestFile(t, fileSrv, sdriver, "a.txt", "abc")
+
+	share, err := shareSrv.CreateShare("alice", file.ID, nil, 2, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := shareSrv.DownloadShareFile(share.ID, "wrong", ""); !errors.Is(err, fileerr.ErrPasswordIsWrong) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// 密码错误不计入下载次数，用完之后不能再下载
+	for i := 0; i < 2; i++ {
+		downloaded, err := shareSrv.DownloadShareFile(share.ID, "secret", "")
+		if err != nil || downloaded.ID != file.ID {
+			t.Fatalf("unexpected download %+v %v", downloaded, err)
+		}
+	}
+	if _, err := shareSrv.DownloadShareFile(share.ID, "secret", ""); !errors.Is(err, fileerr.ErrShareDownloadLimit) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// 过期之后不能再访问
+	future := time.Now().Add(time.Hour)
+	share, err = shareSrv.CreateShare("alice", file.ID, &future, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shareSrv.DownloadShareFile(share.ID, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Model(&models.Share{}).Where("id = ?", share.ID).Update("expire_at", time.Now().Add(-time.Minute)).Error; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shareSrv.DownloadShareFile(share.ID, "", ""); !errors.Is(err, fileerr.ErrShareExpired) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := shareSrv.FindShare(share.ID, ""); !errors.Is(err, fileerr.ErrShareExpired) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,471 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/utils"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// SyncService 按照定时表达式定期同步存储中的文件，每次运行的结果都会被记录下来
+type SyncService interface {
+	Start() error
+
+	CreateJob(username, path, spec string) (*models.SyncJob, error)
+
+	UpdateJob(operator, id, path, spec string, enable bool) (*models.SyncJob, error)
+
+	DeleteJob(operator, id string) (*models.SyncJob, error)
+
+	ListJobs() ([]*models.SyncJob, error)
+
+	ListRuns(jobId string, page, count int) (*models.PageResult, error)
+
+	RunJob(operator, id string) (*models.SyncRun, error)
+
+	StartSync(username, path string) (*models.SyncTask, error)
+
+	ListTasks() []*models.SyncTask
+
+	FindTask(id string) (*models.SyncTask, error)
+
+	CancelTask(id string) (*models.SyncTask, error)
+}
+
+// syncTaskKeep 结束的同步在内存中保留的时间
+const syncTaskKeep = 24 * time.Hour
+
+type syncTask struct {
+	task   models.SyncTask
+	cancel context.CancelFunc
+}
+
+func NewSyncService(db *gorm.DB, fileSrv FileService) SyncService {
+	return &syncService{
+		db:      db,
+		fileSrv: fileSrv,
+		cron:    cron.New(),
+		entries: map[string]cron.EntryID{},
+		running: map[string]bool{},
+		tasks:   map[string]*syncTask{},
+	}
+}
+
+type syncService struct {
+	db      *gorm.DB
+	fileSrv FileService
+	cron    *cron.Cron
+	lock    sync.Mutex
+	entries map[string]cron.EntryID
+	running map[string]bool
+
+	taskLock sync.Mutex
+	tasks    map[string]*syncTask
+}
+
+// Start 加载所有启用的同步任务并开始调度
+func (s *syncService) Start() error {
+
+	jobs := []*models.SyncJob{}
+	if err := s.db.Find(&jobs).Error; err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if err := s.schedule(job); err != nil {
+			log.Printf("同步任务%s的定时表达式%s错误: %s", job.ID, job.Spec, err)
+		}
+	}
+
+	s.cron.Start()
+
+	return nil
+}
+
+func (s *syncService) schedule(job *models.SyncJob) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if entryId, ok := s.entries[job.ID]; ok {
+		s.cron.Remove(entryId)
+		delete(s.entries, job.ID)
+	}
+
+	if !job.Enable.Bool {
+		return nil
+	}
+
+	// 定时运行时以任务创建者的身份运行
+	jobId, owner := job.ID, job.UserName
+	entryId, err := s.cron.AddFunc(job.Spec, func() {
+		if _, err := s.RunJob(owner, jobId); err != nil && !errors.Is(err, fileerr.ErrSyncJobRunning) {
+			log.Printf("同步任务%s运行失败: %s", jobId, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	s.entries[job.ID] = entryId
+
+	return nil
+}
+
+func (s *syncService) CreateJob(username, path, spec string) (*models.SyncJob, error) {
+
+	if _, err := cron.ParseStandard(spec); err != nil {
+		return nil, fileerr.ErrInvalidCronSpec
+	}
+
+	job := &models.SyncJob{
+		ID:        uuid.NewString(),
+		UserName:  username,
+		Path:      utils.ParsePath(path),
+		Spec:      spec,
+		Enable:    sql.NullBool{Valid: true, Bool: true},
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.db.Create(job).Error; err != nil {
+		return nil, err
+	}
+
+	return job, s.schedule(job)
+}
+
+func (s *syncService) findJob(id string) (*models.SyncJob, error) {
+
+	if id == "" {
+		return nil, fileerr.ErrSyncJobNotFound
+	}
+
+	job := &models.SyncJob{ID: id}
+	if err := s.db.Where(job).First(job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrSyncJobNotFound
+		}
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// findManagedJob 查找operator可以管理的同步任务，只有任务的创建者和超级管理员可以修改、删除或者运行任务
+func (s *syncService) findManagedJob(operator, id string) (*models.SyncJob, error) {
+
+	job, err := s.findJob(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.UserName == operator {
+		return job, nil
+	}
+
+	op := &models.User{UserName: operator}
+	if err := s.db.Where(op).First(op).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrNotEnoughPermission
+		}
+		return nil, err
+	}
+
+	if op.Role != models.SuperAdminRole {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	return job, nil
+}
+
+func (s *syncService) UpdateJob(operator, id, path, spec string, enable bool) (*models.SyncJob, error) {
+
+	job, err := s.findManagedJob(operator, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if path != "" {
+		job.Path = utils.ParsePath(path)
+	}
+
+	if spec != "" {
+		if _, err := cron.ParseStandard(spec); err != nil {
+			return nil, fileerr.ErrInvalidCronSpec
+		}
+		job.Spec = spec
+	}
+
+	job.Enable = sql.NullBool{Valid: true, Bool: enable}
+
+	if err := s.db.Select("path", "spec", "enable").Updates(job).Error; err != nil {
+		return nil, err
+	}
+
+	return job, s.schedule(job)
+}
+
+func (s *syncService) DeleteJob(operator, id string) (*models.SyncJob, error) {
+
+	job, err := s.findManagedJob(operator, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("job_id = ?", job.ID).Delete(&models.SyncRun{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(job).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	job.Enable = sql.NullBool{Valid: true, Bool: false}
+
+	return job, s.schedule(job)
+}
+
+// ListJobs 列出所有的同步任务，同时返回每个任务最后一次运行的结果
+func (s *syncService) ListJobs() ([]*models.SyncJob, error) {
+
+	jobs := []*models.SyncJob{}
+	if err := s.db.Order("created_at asc").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		run := &models.SyncRun{}
+		err := s.db.Where("job_id = ?", job.ID).Order("start_at desc").First(run).Error
+		if err == nil {
+			job.LastRun = run
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	return jobs, nil
+}
+
+func (s *syncService) ListRuns(jobId string, page, count int) (*models.PageResult, error) {
+
+	if page < 1 {
+		page = 1
+	}
+
+	if count < 1 || count > 50 {
+		count = 50
+	}
+
+	runs := []*models.SyncRun{}
+	if err := s.db.Where("job_id = ?", jobId).Order("start_at desc").Offset((page - 1) * count).Limit(count).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+
+	var total int64
+	if err := s.db.Model(&models.SyncRun{}).Where("job_id = ?", jobId).Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.PageResult{
+		Total:     int(total),
+		Page:      page,
+		PageCount: count,
+		List:      runs,
+	}, nil
+}
+
+// RunJob 在后台运行一次同步任务，同一个任务同时只能运行一个，运行结束后记录结果
+func (s *syncService) RunJob(operator, id string) (*models.SyncRun, error) {
+
+	job, err := s.findManagedJob(operator, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	if s.running[job.ID] {
+		s.lock.Unlock()
+		return nil, fileerr.ErrSyncJobRunning
+	}
+	s.running[job.ID] = true
+	s.lock.Unlock()
+
+	release := func() {
+		s.lock.Lock()
+		delete(s.running, job.ID)
+		s.lock.Unlock()
+	}
+
+	run := &models.SyncRun{
+		ID:      uuid.NewString(),
+		JobId:   job.ID,
+		TaskId:  uuid.NewString(),
+		StartAt: time.Now(),
+	}
+	if err := s.db.Create(run).Error; err != nil {
+		release()
+		return nil, err
+	}
+
+	s.startTask(run.TaskId, job.ID, job.UserName, job.Path, func(task models.SyncTask) {
+		defer release()
+
+		// 错误信息过长时截断，避免超出字段的长度
+		message := []rune(task.Error)
+		if len(message) > 1000 {
+			message = message[:1000]
+		}
+
+		finished := &models.SyncRun{
+			ID:      run.ID,
+			EndAt:   task.EndAt,
+			Scanned: task.Scanned,
+			Added:   task.Added,
+			Updated: task.Updated,
+			Removed: task.Removed,
+			Error:   string(message),
+		}
+
+		if err := s.db.Select("end_at", "scanned", "added", "updated", "removed", "error").Updates(finished).Error; err != nil {
+			log.Printf("保存同步任务%s的运行结果失败: %s", job.ID, err)
+		}
+	})
+
+	return run, nil
+}
+
+// StartSync 在后台同步存储中path目录下的文件，返回的同步可以用来查询进度或者取消
+func (s *syncService) StartSync(username, path string) (*models.SyncTask, error) {
+	return s.startTask(uuid.NewString(), "", username, utils.ParsePath(path), nil), nil
+}
+
+func (s *syncService) startTask(id, jobId, username, path string, done func(task models.SyncTask)) *models.SyncTask {
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t := &syncTask{
+		task: models.SyncTask{
+			ID:       id,
+			JobId:    jobId,
+			UserName: username,
+			Path:     path,
+			Status:   models.SYNCRUNNING,
+			StartAt:  time.Now(),
+		},
+		cancel: cancel,
+	}
+
+	s.taskLock.Lock()
+	s.cleanTasks()
+	s.tasks[id] = t
+	task := t.task
+	s.taskLock.Unlock()
+
+	go func() {
+		defer cancel()
+
+		result, err := s.fileSrv.SyncFiles(ctx, username, path, func(result models.SyncResult, current string) {
+			s.taskLock.Lock()
+			t.task.SyncResult = result
+			t.task.CurrentPath = current
+			s.taskLock.Unlock()
+		})
+
+		s.taskLock.Lock()
+		endAt := time.Now()
+		t.task.EndAt = &endAt
+		t.task.CurrentPath = ""
+		if err == nil {
+			t.task.Status = models.SYNCSUCCESS
+			t.task.SyncResult = *result
+		} else if errors.Is(err, context.Canceled) {
+			t.task.Status = models.SYNCCANCELED
+			t.task.Error = fileerr.ErrSyncCanceled.Error()
+		} else {
+			t.task.Status = models.SYNCFAILED
+			t.task.Error = err.Error()
+		}
+		task := t.task
+		s.taskLock.Unlock()
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("同步%s失败: %s", path, err)
+		}
+
+		if done != nil {
+			done(task)
+		}
+	}()
+
+	return &task
+}
+
+// cleanTasks 清理结束了很久的同步，调用时需要持有taskLock
+func (s *syncService) cleanTasks() {
+	for id, t := range s.tasks {
+		if t.task.EndAt != nil && time.Since(*t.task.EndAt) > syncTaskKeep {
+			delete(s.tasks, id)
+		}
+	}
+}
+
+func (s *syncService) ListTasks() []*models.SyncTask {
+
+	s.taskLock.Lock()
+	defer s.taskLock.Unlock()
+
+	s.cleanTasks()
+
+	tasks := []*models.SyncTask{}
+	for _, t := range s.tasks {
+		task := t.task
+		tasks = append(tasks, &task)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].StartAt.After(tasks[j].StartAt)
+	})
+
+	return tasks
+}
+
+func (s *syncService) FindTask(id string) (*models.SyncTask, error) {
+
+	s.taskLock.Lock()
+	defer s.taskLock.Unlock()
+
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil, fileerr.ErrSyncTaskNotFound
+	}
+
+	task := t.task
+	return &task, nil
+}
+
+// CancelTask 取消正在运行的同步，已经提交的数据不会回滚
+func (s *syncService) CancelTask(id string) (*models.SyncTask, error) {
+
+	s.taskLock.Lock()
+	t, ok := s.tasks[id]
+	s.taskLock.Unlock()
+
+	if !ok {
+		return nil, fileerr.ErrSyncTaskNotFound
+	}
+
+	t.cancel()
+
+	return s.FindTask(id)
+}
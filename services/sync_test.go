@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+)
+
+// waitRun 等待同步任务的这次运行保存结果
+func waitRun(t *testing.T, syncSrv SyncService, jobId, runId string) {
+
+	for i := 0; i < 500; i++ {
+		result, err := syncSrv.ListRuns(jobId, 1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, run := range result.List.([]*models.SyncRun) {
+			if run.ID == runId && run.EndAt != nil {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("run %s is not finished", runId)
+}
+
+func Test_ManageSyncJob(t *testing.T) {
+
+	db := newTestDB(t)
+
+	release := make(chan struct{})
+	hook := &walkHookDriver{Driver: newTestDriver(t), before: func() { <-release }}
+	syncSrv := NewSyncService(db, NewFileService(db, hook))
+
+	for i, user := range []*models.User{
+		{UserName: "admin", Role: models.AdminRole},
+		{UserName: "root", Role: models.SuperAdminRole},
+	} {
+		user.ID = user.UserName
+		user.Email = user.UserName + "@nextlist.com"
+		user.Tel = []string{"13800000000", "13800000001"}[i]
+		if err := db.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	job, err := syncSrv.CreateJob("alice", "/", "@daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只有创建者和超级管理员可以管理任务
+	for _, operator := range []string{"admin", "nobody"} {
+		if _, err := syncSrv.UpdateJob(operator, job.ID, "", "", false); !errors.Is(err, fileerr.ErrNotEnoughPermission) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := syncSrv.RunJob(operator, job.ID); !errors.Is(err, fileerr.ErrNotEnoughPermission) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if _, err := syncSrv.DeleteJob(operator, job.ID); !errors.Is(err, fileerr.ErrNotEnoughPermission) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if _, err := syncSrv.UpdateJob("root", job.ID, "", "@hourly", true); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一个任务同时只能运行一个
+	run, err := syncSrv.RunJob("alice", job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := syncSrv.RunJob("root", job.ID); !errors.Is(err, fileerr.ErrSyncJobRunning) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	close(release)
+	waitRun(t, syncSrv, job.ID, run.ID)
+
+	// 运行结束之后可以再次运行，保存结果之后才会释放任务
+	for i := 0; ; i++ {
+		run, err = syncSrv.RunJob("root", job.ID)
+		if !errors.Is(err, fileerr.ErrSyncJobRunning) || i >= 100 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitRun(t, syncSrv, job.ID, run.ID)
+
+	if _, err := syncSrv.DeleteJob("alice", job.ID); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lixiaofei123/nextlist/driver"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+	"gorm.io/gorm"
+)
+
+// maxPartNumber 对象存储最多支持10000个分片
+const maxPartNumber = 10000
+
+// UploadService 管理分片上传，客户端通过签名链接把每个分片直接上传到存储，全部上传完成后再合并
+type UploadService interface {
+	CreateMultipartUpload(username, fileId string) (*models.MultipartUpload, error)
+
+	PreUploadPartUrl(username, id string, partNumber int) (string, error)
+
+	CompleteMultipartUpload(username, id string, parts []*driver.UploadPart) (*models.File, error)
+
+	AbortMultipartUpload(username, id string) (*models.MultipartUpload, error)
+
+	AbortExpired(before time.Time) (int, error)
+}
+
+func NewUploadService(db *gorm.DB, fileSrv FileService, driver driver.Driver) UploadService {
+	return &uploadService{
+		db:      db,
+		fileSrv: fileSrv,
+		driver:  driver,
+	}
+}
+
+type uploadService struct {
+	db      *gorm.DB
+	fileSrv FileService
+	driver  driver.Driver
+}
+
+func (u *uploadService) uploader() (driver.MultipartUploader, error) {
+
+	uploader, ok := u.driver.(driver.MultipartUploader)
+	if !ok {
+		return nil, fileerr.ErrUnSupportOperation
+	}
+
+	return uploader, nil
+}
+
+// CreateMultipartUpload 为通过PreSaveFile创建的文件开始一次分片上传
+func (u *uploadService) CreateMultipartUpload(username, fileId string) (*models.MultipartUpload, error) {
+
+	uploader, err := u.uploader()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := u.fileSrv.FindUploadingFile(username, fileId)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadId, err := uploader.CreateMultipartUpload(file.AbsolutePath)
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &models.MultipartUpload{
+		ID:        uuid.NewString(),
+		UserName:  username,
+		FileId:    file.ID,
+		Key:       file.AbsolutePath,
+		UploadId:  uploadId,
+		CreatedAt: time.Now(),
+	}
+
+	if err := u.db.Create(upload).Error; err != nil {
+		uploader.AbortMultipartUpload(upload.Key, uploadId)
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+func (u *uploadService) findUpload(username, id string) (*models.MultipartUpload, error) {
+
+	if id == "" {
+		return nil, fileerr.ErrUploadNotFound
+	}
+
+	upload := &models.MultipartUpload{ID: id}
+	if err := u.db.Where(upload).First(upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrUploadNotFound
+		}
+		return nil, err
+	}
+
+	if upload.UserName != username {
+		return nil, fileerr.ErrUploadNotFound
+	}
+
+	return upload, nil
+}
+
+// PreUploadPartUrl 返回上传某个分片的签名链接，除了最后一个分片，每个分片最小为5M
+func (u *uploadService) PreUploadPartUrl(username, id string, partNumber int) (string, error) {
+
+	if partNumber < 1 || partNumber > maxPartNumber {
+		return "", fileerr.ErrInvalidPartNumber
+	}
+
+	uploader, err := u.uploader()
+	if err != nil {
+		return "", err
+	}
+
+	upload, err := u.findUpload(username, id)
+	if err != nil {
+		return "", err
+	}
+
+	return uploader.PreUploadPartUrl(upload.Key, upload.UploadId, partNumber)
+}
+
+// CompleteMultipartUpload 合并所有的分片，然后确认文件
+func (u *uploadService) CompleteMultipartUpload(username, id string, parts []*driver.UploadPart) (*models.File, error) {
+
+	if len(parts) == 0 {
+		return nil, fileerr.ErrEmptyUploadParts
+	}
+
+	for _, part := range parts {
+		if part == nil || part.PartNumber < 1 || part.PartNumber > maxPartNumber {
+			return nil, fileerr.ErrInvalidPartNumber
+		}
+	}
+
+	uploader, err := u.uploader()
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := u.findUpload(username, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 分片需要按照编号从小到大的顺序合并
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+
+	if err := uploader.CompleteMultipartUpload(upload.Key, upload.UploadId, parts); err != nil {
+		return nil, err
+	}
+
+	if err := u.db.Delete(upload).Error; err != nil {
+		return nil, err
+	}
+
+	return u.fileSrv.ConfirmUpload(username, upload.FileId)
+}
+
+// AbortMultipartUpload 取消分片上传，已经上传的分片会被存储删除
+func (u *uploadService) AbortMultipartUpload(username, id string) (*models.MultipartUpload, error) {
+
+	uploader, err := u.uploader()
+	if err != nil {
+		return nil, err
+	}
+
+	upload, err := u.findUpload(username, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := uploader.AbortMultipartUpload(upload.Key, upload.UploadId); err != nil {
+		return nil, err
+	}
+
+	if err := u.db.Delete(upload).Error; err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+// AbortExpired 取消before之前开始并且还没有完成的分片上传，返回取消的数量
+func (u *uploadService) AbortExpired(before time.Time) (int, error) {
+
+	// 驱动不支持分片上传时不会有需要取消的上传
+	uploader, ok := u.driver.(driver.MultipartUploader)
+	if !ok {
+		return 0, nil
+	}
+
+	uploads := []*models.MultipartUpload{}
+	if err := u.db.Where("created_at < ?", before).Find(&uploads).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, upload := range uploads {
+		if err := uploader.AbortMultipartUpload(upload.Key, upload.UploadId); err != nil {
+			log.Printf("取消分片上传%s失败: %s", upload.ID, err)
+			continue
+		}
+
+		if err := u.db.Delete(upload).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lixiaofei123/nextlist/driver"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+)
+
+// fakeUploader 在本地存储的基础上模拟分片上传，合并时把每个分片的ETag按顺序写入文件
+type fakeUploader struct {
+	driver.Driver
+	uploads  int
+	aborted  []string
+	failKeys map[string]bool
+}
+
+func (d *fakeUploader) CreateMultipartUpload(key string) (string, error) {
+	d.uploads++
+	return fmt.Sprintf("upload-%d", d.uploads), nil
+}
+
+func (d *fakeUploader) PreUploadPartUrl(key string, uploadId string, partNumber int) (string, error) {
+	return fmt.Sprintf("http://127.0.0.1%s?uploadId=%s&partNumber=%d", key, uploadId, partNumber), nil
+}
+
+func (d *fakeUploader) CompleteMultipartUpload(key string, uploadId string, parts []*driver.UploadPart) error {
+
+	etags := []string{}
+	for _, part := range parts {
+		etags = append(etags, part.ETag)
+	}
+	data := strings.Join(etags, "")
+
+	return d.Driver.(driver.Putter).Put(key, strings.NewReader(data), int64(len(data)))
+}
+
+func (d *fakeUploader) AbortMultipartUpload(key string, uploadId string) error {
+	if d.failKeys[key] {
+		return errors.New("abort failed")
+	}
+	d.aborted = append(d.aborted, key)
+	return nil
+}
+
+func newTestUploadService(t *testing.T) (UploadService, FileService, *fakeUploader) {
+
+	db := newTestDB(t)
+	uploader := &fakeUploader{Driver: newTestDriver(t), failKeys: map[string]bool{}}
+	fileSrv := NewFileService(db, uploader)
+
+	return NewUploadService(db, fileSrv, uploader), fileSrv, uploader
+}
+
+func Test_CompleteMultipartUpload(t *testing.T) {
+
+	uploadSrv, fileSrv, _ := newTestUploadService(t)
+
+	file, err := fileSrv.PreSaveFile("alice", &models.File{Name: "a.bin", FileSize: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload, err := uploadSrv.CreateMultipartUpload("alice", file.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, partNumber := range []int{0, maxPartNumber + 1} {
+		if _, err := uploadSrv.PreUploadPartUrl("alice", upload.ID, partNumber); !errors.Is(err, fileerr.ErrInvalidPartNumber) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+
+	// 别人不能使用这个上传
+	if _, err := uploadSrv.PreUploadPartUrl("bob", upload.ID, 1); !errors.Is(err, fileerr.ErrUploadNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := uploadSrv.CompleteMultipartUpload("bob", upload.ID, []*driver.UploadPart{{PartNumber: 1, ETag: "ab"}}); !errors.Is(err, fileerr.ErrUploadNotFound) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := uploadSrv.PreUploadPartUrl("alice", upload.ID, maxPartNumber); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分片按照编号排序之后再合并，合并完成后文件被确认
+	confirmed, err := uploadSrv.CompleteMultipartUpload("alice", upload.ID, []*driver.UploadPart{
+		{PartNumber: 3, ETag: "ef"},
+		{PartNumber: 1, ETag: "ab"},
+		{PartNumber: 2, ETag: "cd"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirmed.FileStatus != models.SUCCESS || confirmed.FileSize != 6 {
+		t.Fatalf("unexpected file %+v", confirmed)
+	}
+
+	if _, err := uploadSrv.AbortMultipartUpload("alice", upload.ID); !errors.Is(err, fileerr.ErrUploadNotFound) {
+		t.Fatalf("upload should be removed %v", err)
+	}
+}
+
+func Test_AbortExpired(t *testing.T) {
+
+	uploadSrv, fileSrv, uploader := newTestUploadService(t)
+
+	for _, name := range []string{"a.bin", "b.bin"} {
+		file, err := fileSrv.PreSaveFile("alice", &models.File{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := uploadSrv.CreateMultipartUpload("alice", file.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 没有过期的上传不会被取消
+	count, err := uploadSrv.AbortExpired(time.Now().Add(-time.Hour))
+	if err != nil || count != 0 {
+		t.Fatalf("unexpected result %d %v", count, err)
+	}
+
+	// 取消失败的上传会被跳过，下一次再重试
+	uploader.failKeys["/a.bin"] = true
+	count, err = uploadSrv.AbortExpired(time.Now().Add(time.Hour))
+	if err != nil || count != 1 || strings.Join(uploader.aborted, ",") != "/b.bin" {
+		t.Fatalf("unexpected result %d %v %v", count, uploader.aborted, err)
+	}
+
+	delete(uploader.failKeys, "/a.bin")
+	count, err = uploadSrv.AbortExpired(time.Now().Add(time.Hour))
+	if err != nil || count != 1 || strings.Join(uploader.aborted, ",") != "/b.bin,/a.bin" {
+		t.Fatalf("unexpected result %d %v %v", count, uploader.aborted, err)
+	}
+}
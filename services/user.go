@@ -2,13 +2,19 @@ package services
 
 import (
 	"crypto/md5"
+	"crypto/subtle"
+	"database/sql"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
 	models "github.com/lixiaofei123/nextlist/models"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -24,9 +30,38 @@ const (
 	Tel      LoginType = 2
 )
 
-func MD5Password(user *models.User) string {
-	data := []byte(fmt.Sprintf("(%s)(%s)", user.ID, user.Password))
-	return fmt.Sprintf("%x", md5.Sum(data))
+// passwordCost 是bcrypt的计算强度，调高后旧的哈希会在用户下次登录时重新计算
+const passwordCost int = 12
+
+// HashPassword 使用bcrypt计算密码的哈希，结果以$2a$开头，可以和旧版本的MD5哈希区分开
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword 校验用户的密码，同时兼容旧版本中保存的MD5哈希。
+// 密码正确但是哈希需要升级时needRehash为true
+func CheckPassword(user *models.User, password string) (ok bool, needRehash bool) {
+
+	if strings.HasPrefix(user.Password, "$2") {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return false, false
+		}
+		cost, err := bcrypt.Cost([]byte(user.Password))
+		return true, err != nil || cost < passwordCost
+	}
+
+	// 旧版本的哈希为md5("(id)(password)")
+	data := []byte(fmt.Sprintf("(%s)(%s)", user.ID, password))
+	legacy := fmt.Sprintf("%x", md5.Sum(data))
+	if subtle.ConstantTimeCompare([]byte(legacy), []byte(user.Password)) != 1 {
+		return false, false
+	}
+
+	return true, true
 }
 
 type UserService interface {
@@ -35,16 +70,30 @@ type UserService interface {
 	Register(user *models.User) (*models.User, error)
 
 	UserCount() (int64, error)
+
+	FindByName(username string) (*models.User, error)
+
+	ListUsers(keyword string, page, count int) (*models.PageResult, error)
+
+	EnableUser(operator, userId string, enable bool) (*models.User, error)
+
+	ChangeRole(operator, userId string, role models.Role) (*models.User, error)
+
+	ResetPassword(operator, userId, password string) (*models.User, error)
+
+	DeleteUser(operator, userId, transferTo string) (*models.DeleteUserResult, error)
 }
 
-func NewUserService(db *gorm.DB) UserService {
+func NewUserService(db *gorm.DB, fileSrv FileService) UserService {
 	return &userService{
-		db: db,
+		db:      db,
+		fileSrv: fileSrv,
 	}
 }
 
 type userService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	fileSrv FileService
 }
 
 func (u *userService) UserCount() (int64, error) {
@@ -78,12 +127,24 @@ func (u *userService) Login(username string, password string, loginType LoginTyp
 		return nil, err
 	}
 
-	encryPassword := user.Password
-	user.Password = password
-	if MD5Password(user) != encryPassword {
+	ok, needRehash := CheckPassword(user, password)
+	if !ok {
 		return nil, errors.New("密码错误，请重试")
 	}
 
+	if user.Disabled() {
+		return nil, fileerr.ErrUserDisabled
+	}
+
+	// 登录成功时把旧的哈希升级为新的哈希，失败时不影响本次登录
+	if needRehash {
+		if hash, err := HashPassword(password); err == nil {
+			if err := u.db.Model(user).Update("password", hash).Error; err != nil {
+				log.Printf("更新用户%s的密码哈希失败: %s", user.UserName, err)
+			}
+		}
+	}
+
 	user.Password = ""
 
 	return user, nil
@@ -98,14 +159,268 @@ func (u *userService) Register(user *models.User) (*models.User, error) {
 
 	// 校验通过
 	user.ID = uuid.NewString()
-	user.Role = models.UserRole
-	user.Password = MD5Password(user)
+	user.Password, err = HashPassword(user.Password)
+	if err != nil {
+		return nil, err
+	}
 	user.CreatedAt = time.Now()
 
-	if err := u.db.Create(user).Error; err != nil {
+	if err := u.db.Transaction(func(tx *gorm.DB) error {
+
+		// 第一个注册的用户是超级管理员
+		var count int64
+		if err := tx.Model(&models.User{}).Count(&count).Error; err != nil {
+			return err
+		}
+
+		user.Role = models.UserRole
+		if count == 0 {
+			user.Role = models.SuperAdminRole
+		}
+
+		return tx.Create(user).Error
+	}); err != nil {
 		return nil, err
 	}
 
 	user.Password = ""
 	return user, nil
 }
+
+func (u *userService) FindByName(username string) (*models.User, error) {
+
+	if username == "" {
+		return nil, fileerr.ErrUserNotFound
+	}
+
+	user := &models.User{UserName: username}
+	if err := u.db.Where(user).First(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fileerr.ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// ListUsers 按照注册时间列出用户，keyword会匹配用户名、昵称、邮箱以及手机号
+func (u *userService) ListUsers(keyword string, page, count int) (*models.PageResult, error) {
+
+	if page < 1 {
+		page = 1
+	}
+
+	if count < 1 || count > 50 {
+		count = 50
+	}
+
+	query := func() *gorm.DB {
+		tx := u.db.Model(&models.User{})
+		if keyword != "" {
+			like := "%" + keyword + "%"
+			tx = tx.Where("user_name like ? or show_name like ? or email like ? or tel like ?", like, like, like, like)
+		}
+		return tx
+	}
+
+	users := []*models.User{}
+	if err := query().Order("created_at").Offset((page - 1) * count).Limit(count).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		user.Password = ""
+	}
+
+	var total int64
+	if err := query().Count(&total).Error; err != nil {
+		return nil, err
+	}
+
+	return &models.PageResult{
+		Total:     int(total),
+		Page:      page,
+		PageCount: count,
+		List:      users,
+	}, nil
+}
+
+// findManaged 查找operator可以管理的用户，除了超级管理员，只能管理角色比自己低的用户
+func (u *userService) findManaged(tx *gorm.DB, operator, userId string) (*models.User, *models.User, error) {
+
+	op := &models.User{UserName: operator}
+	if err := tx.Where(op).First(op).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fileerr.ErrNotEnoughPermission
+		}
+		return nil, nil, err
+	}
+
+	if userId == "" {
+		return nil, nil, fileerr.ErrUserNotFound
+	}
+
+	user := &models.User{ID: userId}
+	if err := tx.Where(user).First(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fileerr.ErrUserNotFound
+		}
+		return nil, nil, err
+	}
+
+	if op.ID != user.ID && op.Role != models.SuperAdminRole && user.Role.Includes(op.Role) {
+		return nil, nil, fileerr.ErrNotEnoughPermission
+	}
+
+	return op, user, nil
+}
+
+// EnableUser 启用或者禁用用户，被禁用的用户不能登录，已经签发的token也会失效
+func (u *userService) EnableUser(operator, userId string, enable bool) (*models.User, error) {
+
+	op, user, err := u.findManaged(u.db, operator, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.ID == user.ID {
+		return nil, fileerr.ErrOperateSelf
+	}
+
+	user.Enable = sql.NullBool{Valid: true, Bool: enable}
+	if err := u.db.Model(user).Update("enable", user.Enable).Error; err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// ChangeRole 修改用户的角色，除了超级管理员，只能把用户设置为比自己低的角色
+func (u *userService) ChangeRole(operator, userId string, role models.Role) (*models.User, error) {
+
+	if !role.Valid() {
+		return nil, fileerr.ErrUnknownRole
+	}
+
+	op, user, err := u.findManaged(u.db, operator, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	// 不能修改自己的角色，否则站点可能会失去最后一个超级管理员
+	if op.ID == user.ID {
+		return nil, fileerr.ErrOperateSelf
+	}
+
+	if op.Role != models.SuperAdminRole && role.Includes(op.Role) {
+		return nil, fileerr.ErrNotEnoughPermission
+	}
+
+	user.Role = role
+	if err := u.db.Model(user).Update("role", role).Error; err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+func (u *userService) ResetPassword(operator, userId, password string) (*models.User, error) {
+
+	// 和注册时的校验规则保持一致
+	if err := validate.Var(password, "required,min=10,max=20"); err != nil {
+		return nil, err
+	}
+
+	_, user, err := u.findManaged(u.db, operator, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := u.db.Model(user).Update("password", hash).Error; err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	return user, nil
+}
+
+// DeleteUser 删除用户。transferTo不为空时把用户的文件、分享以及同步任务转交给这个用户，
+// 否则彻底删除用户的文件以及分享，无法删除的文件和同步任务转交给执行删除的管理员
+func (u *userService) DeleteUser(operator, userId, transferTo string) (*models.DeleteUserResult, error) {
+
+	op, user, err := u.findManaged(u.db, operator, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if op.ID == user.ID {
+		return nil, fileerr.ErrOperateSelf
+	}
+
+	result := &models.DeleteUserResult{
+		User: user,
+	}
+
+	remove := transferTo == ""
+	if remove {
+		// 删除文件需要访问存储，不放在事务中
+		result.Report, err = u.fileSrv.DeleteUserFiles(user.UserName)
+		if err != nil {
+			return nil, err
+		}
+		transferTo = op.UserName
+	} else {
+		receiver := &models.User{UserName: transferTo}
+		if err := u.db.Where(receiver).First(receiver).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fileerr.ErrUserNotFound
+			}
+			return nil, err
+		}
+		if receiver.ID == user.ID {
+			return nil, fileerr.ErrOperateSelf
+		}
+	}
+
+	if err := u.db.Transaction(func(tx *gorm.DB) error {
+
+		// 回收站中的文件也一起转交
+		files := tx.Unscoped().Model(&models.File{}).Where("user_name = ?", user.UserName).Update("user_name", transferTo)
+		if files.Error != nil {
+			return files.Error
+		}
+		result.Transferred = files.RowsAffected
+
+		if remove {
+			if err := tx.Where("user_name = ?", user.UserName).Delete(&models.Share{}).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Model(&models.Share{}).Where("user_name = ?", user.UserName).Update("user_name", transferTo).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.SyncJob{}).Where("user_name = ?", user.UserName).Update("user_name", transferTo).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.MultipartUpload{}).Where("user_name = ?", user.UserName).Update("user_name", transferTo).Error; err != nil {
+			return err
+		}
+
+		return tx.Delete(user).Error
+	}); err != nil {
+		return nil, err
+	}
+
+	user.Password = ""
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/lixiaofei123/nextlist/driver"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	models "github.com/lixiaofei123/nextlist/models"
+)
+
+func Test_LoginRehashLegacyPassword(t *testing.T) {
+
+	db := newTestDB(t)
+	userSrv := NewUserService(db, NewFileService(db, newTestDriver(t)))
+
+	// 旧版本保存的是md5("(id)(password)")
+	user := &models.User{
+		ID:       "legacy",
+		UserName: "legacy",
+		Email:    "legacy@example.com",
+		Tel:      "13800000000",
+		Password: fmt.Sprintf("%x", md5.Sum([]byte("(legacy)(password123)"))),
+	}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := userSrv.Login("legacy", "wrongpassword", UserName); err == nil {
+		t.Fatal("expect wrong password error")
+	}
+
+	if _, err := userSrv.Login("legacy", "password123", UserName); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := &models.User{ID: "legacy"}
+	if err := db.First(saved).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(saved.Password, "$2a$") {
+		t.Fatalf("password should be rehashed, got %s", saved.Password)
+	}
+
+	if _, err := userSrv.Login("legacy", "password123", UserName); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_RegisterFirstUserIsSuperAdmin(t *testing.T) {
+
+	db := newTestDB(t)
+	userSrv := NewUserService(db, NewFileService(db, newTestDriver(t)))
+
+	roles := []models.Role{}
+	for _, name := range []string{"first", "second"} {
+		user, err := userSrv.Register(&models.User{
+			UserName: name + "user",
+			ShowName: name + "user",
+			Email:    name + "@example.com",
+			Tel:      "1380000000" + name[:1],
+			Password: "password123",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		roles = append(roles, user.Role)
+	}
+
+	if roles[0] != models.SuperAdminRole || roles[1] != models.UserRole {
+		t.Fatalf("unexpected roles %v", roles)
+	}
+}
+
+func Test_DisableAndDeleteUser(t *testing.T) {
+
+	db := newTestDB(t)
+	sdriver := newTestDriver(t)
+	fileSrv := NewFileService(db, sdriver)
+	userSrv := NewUserService(db, fileSrv)
+
+	users := map[string]*models.User{}
+	for i, name := range []string{"admin", "alice", "bobby"} {
+		user, err := userSrv.Register(&models.User{
+			UserName: name + "user",
+			ShowName: name + "user",
+			Email:    name + "@example.com",
+			Tel:      fmt.Sprintf("1380000000%d", i),
+			Password: "password123",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = user
+	}
+
+	if _, err := userSrv.EnableUser("adminuser", users["alice"].ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := userSrv.Login("aliceuser", "password123", UserName); !errors.Is(err, fileerr.ErrUserDisabled) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// 普通用户不能管理其他用户
+	if _, err := userSrv.EnableUser("bobbyuser", users["alice"].ID, true); !errors.Is(err, fileerr.ErrNotEnoughPermission) {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	upload := func(username, name string) *models.File {
+		file, err := fileSrv.PreSaveFile(username, &models.File{Name: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sdriver.(driver.Putter).Put(file.AbsolutePath, strings.NewReader("abc"), 3); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fileSrv.FinishUpload(username, file.ID, 3); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	aliceFile := upload("aliceuser", "alice.txt")
+	bobbyFile := upload("bobbyuser", "bobby.txt")
+
+	result, err := userSrv.DeleteUser("adminuser", users["alice"].ID, "bobbyuser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Transferred != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	file := &models.File{ID: aliceFile.ID}
+	if err := db.First(file).Error; err != nil || file.UserName != "bobbyuser" {
+		t.Fatalf("file should be transferred %+v %v", file, err)
+	}
+
+	result, err = userSrv.DeleteUser("adminuser", users["bobby"].ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Report == nil || result.Report.Deleted != 2 || len(result.Report.Failures) != 0 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if _, err := sdriver.(driver.Stater).Stat(bobbyFile.AbsolutePath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("object should be deleted %v", err)
+	}
+
+	if count, err := userSrv.UserCount(); err != nil || count != 1 {
+		t.Fatalf("unexpected user count %d %v", count, err)
+	}
+}
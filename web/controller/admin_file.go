@@ -23,6 +23,15 @@ func NewAdminFileController(fileSrv services.FileService, driver driver.Driver)
 	}
 }
 
+// RequiredRole 普通用户只能管理自己已经上传的文件，上传文件、创建文件夹以及移动和复制文件需要管理员权限
+func (f *AdminFileController) RequiredRole(funcName string) models.Role {
+	switch funcName {
+	case "PostDriverSignDelete", "DeleteFileBy", "GetTrash", "PostRestoreBy", "DeleteTrashBy", "PostRenameBy":
+		return models.UserRole
+	}
+	return models.AdminRole
+}
+
 func (f *AdminFileController) PostDriverSignUpload(ctx echo.Context) mvc.Result {
 
 	key := utils.GetValue(ctx, "key")
@@ -51,6 +60,11 @@ func (f *AdminFileController) PostDriverSignDelete(ctx echo.Context) mvc.Result
 		return HandleData(nil, fileerr.ErrNotEnoughPermission)
 	}
 
+	// 上传完成的文件删除后进入回收站，存储中的文件在清理回收站时删除
+	if file.FileStatus == models.SUCCESS {
+		return HandleData(nil, fileerr.ErrDeleteByTrash)
+	}
+
 	//然后才是删除
 	urlStr, err := f.driver.PreDeleteUrl(key)
 
@@ -61,19 +75,6 @@ func (f *AdminFileController) PostDriverSignDelete(ctx echo.Context) mvc.Result
 	return HandleData(urlStr, nil)
 }
 
-func (f *AdminFileController) PostSync(ctx echo.Context) mvc.Result {
-
-	path := utils.GetValueWithDefault(ctx, "path", "/")
-	username := ctx.Request().Header.Get("username")
-
-	err := f.fileSrv.SyncFiles(username, path)
-	if err != nil {
-		return HandleData(nil, err)
-	}
-
-	return HandleData("OK", nil)
-}
-
 func (f *AdminFileController) PutDirBy(ctx echo.Context, parentid string) mvc.Result {
 
 	name := utils.GetValueWithDefault(ctx, "name", "empty")
@@ -124,6 +125,16 @@ func (f *AdminFileController) PostFile(ctx echo.Context, parentid string) mvc.Re
 func (f *AdminFileController) DeleteFileBy(ctx echo.Context, fileid string) mvc.Result {
 
 	username := ctx.Request().Header.Get("username")
+
+	// 递归删除时直接删除文件夹中所有的文件以及存储中的文件，不进入回收站
+	if utils.GetValueWithDefault(ctx, "recursive", "false") == "true" {
+		report, err := f.fileSrv.DeleteFileRecursive(username, fileid)
+		if err != nil {
+			return HandleData(nil, err)
+		}
+		return HandleData(report, nil)
+	}
+
 	file, err := f.fileSrv.DeleteFile(username, fileid)
 	if err != nil {
 		return HandleData(nil, err)
@@ -132,12 +143,95 @@ func (f *AdminFileController) DeleteFileBy(ctx echo.Context, fileid string) mvc.
 	return HandleData(file, nil)
 }
 
-// 确认执行成功
+func (f *AdminFileController) GetTrash(ctx echo.Context) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	page := utils.GetIntValueWithDefault(ctx, "page", 1)
+	count := utils.GetIntValueWithDefault(ctx, "count", 50)
+
+	result, err := f.fileSrv.ListTrash(username, page, count)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(result, nil)
+}
+
+func (f *AdminFileController) PostRestoreBy(ctx echo.Context, fileid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+
+	file, err := f.fileSrv.RestoreFile(username, fileid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(file, nil)
+}
+
+// 彻底删除回收站中的文件
+func (f *AdminFileController) DeleteTrashBy(ctx echo.Context, fileid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+
+	file, err := f.fileSrv.PurgeFile(username, fileid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(file, nil)
+}
+
+func (f *AdminFileController) PostMoveBy(ctx echo.Context, fileid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	parentId := utils.GetValueWithDefault(ctx, "parentId", "")
+	name := utils.GetValueWithDefault(ctx, "name", "")
+
+	file, err := f.fileSrv.MoveFile(username, fileid, parentId, name)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(file, nil)
+}
+
+func (f *AdminFileController) PostRenameBy(ctx echo.Context, fileid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	name := utils.GetValueWithDefault(ctx, "name", "")
+	if name == "" {
+		return HandleData(nil, fileerr.ErrUnAllowFileName)
+	}
+
+	file, err := f.fileSrv.RenameFile(username, fileid, name)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(file, nil)
+}
+
+func (f *AdminFileController) PostCopyBy(ctx echo.Context, fileid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	parentId := utils.GetValueWithDefault(ctx, "parentId", "")
+	name := utils.GetValueWithDefault(ctx, "name", "")
+
+	file, err := f.fileSrv.CopyFile(username, fileid, parentId, name)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(file, nil)
+}
+
+// 确认上传成功，存储中没有找到上传的文件时会返回错误
 func (f *AdminFileController) PostConfirmFileBy(ctx echo.Context, fileid string) mvc.Result {
 
 	username := ctx.Request().Header.Get("username")
 
-	file, err := f.fileSrv.UpdateFileStatus(username, fileid, models.SUCCESS)
+	file, err := f.fileSrv.ConfirmUpload(username, fileid)
 	if err != nil {
 		return HandleData(nil, err)
 	}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/models"
+	services "github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/utils"
+	mvc "github.com/lixiaofei123/nextlist/web/mvc"
+)
+
+type AdminShareController struct {
+	shareSrv services.ShareService
+}
+
+func NewAdminShareController(shareSrv services.ShareService) *AdminShareController {
+	return &AdminShareController{
+		shareSrv: shareSrv,
+	}
+}
+
+// RequiredRole 普通用户也可以分享自己的文件
+func (s *AdminShareController) RequiredRole(funcName string) models.Role {
+	return models.UserRole
+}
+
+// 创建分享，expireAt为过期时间的秒级时间戳，maxDownloads为最大下载次数，都为0时不限制
+func (s *AdminShareController) PutShareBy(ctx echo.Context, fileid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	expireAt := utils.GetIntValueWithDefault(ctx, "expireAt", 0)
+	maxDownloads := utils.GetIntValueWithDefault(ctx, "maxDownloads", 0)
+	password := utils.GetValueWithDefault(ctx, "password", "")
+
+	var expireTime *time.Time
+	if expireAt > 0 {
+		t := time.Unix(int64(expireAt), 0)
+		expireTime = &t
+	}
+
+	share, err := s.shareSrv.CreateShare(username, fileid, expireTime, maxDownloads, password)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(share, nil)
+}
+
+func (s *AdminShareController) GetShares(ctx echo.Context) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	page := utils.GetIntValueWithDefault(ctx, "page", 1)
+	count := utils.GetIntValueWithDefault(ctx, "count", 50)
+
+	result, err := s.shareSrv.ListShares(username, page, count)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(result, nil)
+}
+
+func (s *AdminShareController) DeleteShareBy(ctx echo.Context, shareid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+
+	share, err := s.shareSrv.DeleteShare(username, shareid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(share, nil)
+}
@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/models"
+	services "github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/utils"
+	mvc "github.com/lixiaofei123/nextlist/web/mvc"
+)
+
+type AdminSyncController struct {
+	syncSrv services.SyncService
+}
+
+func NewAdminSyncController(syncSrv services.SyncService) *AdminSyncController {
+	return &AdminSyncController{
+		syncSrv: syncSrv,
+	}
+}
+
+// RequiredRole 同步会把存储中的文件添加到数据库中，需要管理员权限
+func (s *AdminSyncController) RequiredRole(funcName string) models.Role {
+	return models.AdminRole
+}
+
+// 在后台同步存储中的文件，返回的同步ID可以用来查询进度或者取消同步
+func (s *AdminSyncController) PostSync(ctx echo.Context) mvc.Result {
+
+	path := utils.GetValueWithDefault(ctx, "path", "/")
+	username := ctx.Request().Header.Get("username")
+
+	task, err := s.syncSrv.StartSync(username, path)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(task, nil)
+}
+
+func (s *AdminSyncController) GetSynctasks(ctx echo.Context) mvc.Result {
+	return HandleData(s.syncSrv.ListTasks(), nil)
+}
+
+// 查询同步的进度，包括已经扫描和添加的文件数量以及当前正在处理的路径
+func (s *AdminSyncController) GetSynctaskBy(ctx echo.Context, taskid string) mvc.Result {
+
+	task, err := s.syncSrv.FindTask(taskid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(task, nil)
+}
+
+// 取消正在运行的同步
+func (s *AdminSyncController) DeleteSynctaskBy(ctx echo.Context, taskid string) mvc.Result {
+
+	task, err := s.syncSrv.CancelTask(taskid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(task, nil)
+}
+
+func (s *AdminSyncController) GetSyncjobs(ctx echo.Context) mvc.Result {
+
+	jobs, err := s.syncSrv.ListJobs()
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(jobs, nil)
+}
+
+// 创建同步任务，spec为cron表达式，例如 "0 3 * * *" 或者 "@hourly"
+func (s *AdminSyncController) PutSyncjob(ctx echo.Context) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	path := utils.GetValueWithDefault(ctx, "path", "/")
+	spec := utils.GetValueWithDefault(ctx, "spec", "")
+
+	job, err := s.syncSrv.CreateJob(username, path, spec)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(job, nil)
+}
+
+func (s *AdminSyncController) PostSyncjobBy(ctx echo.Context, jobid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	path := utils.GetValueWithDefault(ctx, "path", "")
+	spec := utils.GetValueWithDefault(ctx, "spec", "")
+	enable := utils.GetValueWithDefault(ctx, "enable", "true") == "true"
+
+	job, err := s.syncSrv.UpdateJob(username, jobid, path, spec, enable)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(job, nil)
+}
+
+func (s *AdminSyncController) DeleteSyncjobBy(ctx echo.Context, jobid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	job, err := s.syncSrv.DeleteJob(username, jobid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(job, nil)
+}
+
+// 立即运行一次同步任务
+func (s *AdminSyncController) PostSyncjobRunBy(ctx echo.Context, jobid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	run, err := s.syncSrv.RunJob(username, jobid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(run, nil)
+}
+
+func (s *AdminSyncController) GetSyncjobRunsBy(ctx echo.Context, jobid string) mvc.Result {
+
+	page := utils.GetIntValueWithDefault(ctx, "page", 1)
+	count := utils.GetIntValueWithDefault(ctx, "count", 50)
+
+	result, err := s.syncSrv.ListRuns(jobid, page, count)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(result, nil)
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/driver"
+	"github.com/lixiaofei123/nextlist/models"
+	services "github.com/lixiaofei123/nextlist/services"
+	mvc "github.com/lixiaofei123/nextlist/web/mvc"
+)
+
+type AdminUploadController struct {
+	uploadSrv services.UploadService
+}
+
+func NewAdminUploadController(uploadSrv services.UploadService) *AdminUploadController {
+	return &AdminUploadController{
+		uploadSrv: uploadSrv,
+	}
+}
+
+// RequiredRole 分片上传需要管理员权限
+func (u *AdminUploadController) RequiredRole(funcName string) models.Role {
+	return models.AdminRole
+}
+
+type multipartParts struct {
+	Parts []*driver.UploadPart `json:"parts"`
+}
+
+// 为已经创建的文件开始分片上传，适用于超过5G的文件
+func (u *AdminUploadController) PutMultipartBy(ctx echo.Context, fileid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+
+	upload, err := u.uploadSrv.CreateMultipartUpload(username, fileid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(upload, nil)
+}
+
+// 获取上传某个分片的签名链接，分片编号从1开始
+func (u *AdminUploadController) PostMultipartPartBy(ctx echo.Context, uploadid string, partnumber int) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+
+	urlStr, err := u.uploadSrv.PreUploadPartUrl(username, uploadid, partnumber)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(urlStr, nil)
+}
+
+// 所有分片上传完成后合并分片，parts中需要包含每个分片的编号以及上传时返回的ETag
+func (u *AdminUploadController) PostMultipartCompleteBy(ctx echo.Context, uploadid string, parts multipartParts) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+
+	file, err := u.uploadSrv.CompleteMultipartUpload(username, uploadid, parts.Parts)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(file, nil)
+}
+
+func (u *AdminUploadController) DeleteMultipartBy(ctx echo.Context, uploadid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+
+	upload, err := u.uploadSrv.AbortMultipartUpload(username, uploadid)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(upload, nil)
+}
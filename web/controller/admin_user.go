@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/models"
+	services "github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/utils"
+	mvc "github.com/lixiaofei123/nextlist/web/mvc"
+)
+
+type AdminUserController struct {
+	userSrv services.UserService
+}
+
+func NewAdminUserController(userSrv services.UserService) *AdminUserController {
+	return &AdminUserController{
+		userSrv: userSrv,
+	}
+}
+
+// RequiredRole 管理用户需要管理员权限，修改角色需要超级管理员权限
+func (u *AdminUserController) RequiredRole(funcName string) models.Role {
+	if funcName == "PostUserRoleBy" {
+		return models.SuperAdminRole
+	}
+	return models.AdminRole
+}
+
+// 列出所有的用户，keyword可以匹配用户名、昵称、邮箱以及手机号
+func (u *AdminUserController) GetUsers(ctx echo.Context) mvc.Result {
+
+	keyword := utils.GetValueWithDefault(ctx, "keyword", "")
+	page := utils.GetIntValueWithDefault(ctx, "page", 1)
+	count := utils.GetIntValueWithDefault(ctx, "count", 50)
+
+	result, err := u.userSrv.ListUsers(keyword, page, count)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(result, nil)
+}
+
+// 启用或者禁用用户，enable为false时禁用
+func (u *AdminUserController) PostUserEnableBy(ctx echo.Context, userid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	enable := utils.GetValueWithDefault(ctx, "enable", "true") == "true"
+
+	user, err := u.userSrv.EnableUser(username, userid, enable)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(user, nil)
+}
+
+// 修改用户的角色，role为superadmin、admin或者user
+func (u *AdminUserController) PostUserRoleBy(ctx echo.Context, userid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	role := utils.GetValueWithDefault(ctx, "role", "")
+
+	user, err := u.userSrv.ChangeRole(username, userid, models.Role(role))
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(user, nil)
+}
+
+// 重置用户的密码，密码的长度为10到20个字符
+func (u *AdminUserController) PostUserPasswordBy(ctx echo.Context, userid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	password := utils.GetValueWithDefault(ctx, "password", "")
+
+	user, err := u.userSrv.ResetPassword(username, userid, password)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(user, nil)
+}
+
+// 删除用户，transferTo为接收文件的用户名，为空时彻底删除用户的所有文件
+func (u *AdminUserController) DeleteUserBy(ctx echo.Context, userid string) mvc.Result {
+
+	username := ctx.Request().Header.Get("username")
+	transferTo := utils.GetValueWithDefault(ctx, "transferTo", "")
+
+	result, err := u.userSrv.DeleteUser(username, userid, transferTo)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(result, nil)
+}
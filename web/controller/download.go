@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	services "github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/utils"
+	mvc "github.com/lixiaofei123/nextlist/web/mvc"
+)
+
+// DownloadController 提供不会过期的文件路径链接，每次访问时重新签发下载链接
+type DownloadController struct {
+	fileSrv services.FileService
+}
+
+func NewDownloadController(fileSrv services.FileService) *DownloadController {
+	return &DownloadController{
+		fileSrv: fileSrv,
+	}
+}
+
+func (d *DownloadController) RegisterRouter(routers mvc.ExtraRouter) {
+	routers.AddRouter("Get", "/:path", "Download")
+}
+
+func (d *DownloadController) Download(ctx echo.Context) mvc.Result {
+
+	path := ctx.Param("path")
+	if ctx.Request().URL.RawPath != "" {
+		unescapePath, err := url.PathUnescape(path)
+		if err != nil {
+			return HandleData(nil, fileerr.ErrFileNotFound)
+		}
+		path = unescapePath
+	}
+
+	username := ctx.Request().Header.Get("username")
+	password := filePassword(ctx)
+
+	file, err := d.fileSrv.FindByPath(utils.ParsePath(path))
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	// 通过FindById检查访问权限以及密码，并生成新的下载链接
+	file, err = d.fileSrv.FindById(username, password, file.ID)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	if file.IsDict.Bool {
+		return HandleData(nil, fileerr.ErrIsDirectory)
+	}
+
+	if len(file.DownloadUrls) == 0 {
+		return HandleData(nil, fileerr.ErrFileNotFound)
+	}
+
+	ctx.Redirect(http.StatusFound, file.DownloadUrls[0].DownloadUrl)
+	return nil
+}
@@ -20,7 +20,7 @@ func NewFileController(fileSrv services.FileService) *FileController {
 func (f *FileController) GetBy(ctx echo.Context, fileid string) mvc.Result {
 
 	username := ctx.Request().Header.Get("username")
-	password := utils.GetValueWithDefault(ctx, "password", "")
+	password := filePassword(ctx)
 
 	file, err := f.fileSrv.FindById(username, password, fileid)
 	if err != nil {
@@ -31,6 +31,19 @@ func (f *FileController) GetBy(ctx echo.Context, fileid string) mvc.Result {
 
 }
 
+// 校验加密文件夹的密码，返回的令牌可以通过unlock参数代替密码访问这个文件夹
+func (f *FileController) PostUnlockBy(ctx echo.Context, fileid string) mvc.Result {
+
+	password := utils.GetValueWithDefault(ctx, "password", "")
+
+	token, err := f.fileSrv.UnlockFile(fileid, password)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(token, nil)
+}
+
 func (f *FileController) GetBaseinfoBy(ctx echo.Context, fileid string) mvc.Result {
 
 	file, err := f.fileSrv.BaseInfo(fileid)
@@ -45,7 +58,7 @@ func (f *FileController) GetBaseinfoBy(ctx echo.Context, fileid string) mvc.Resu
 func (f *FileController) GetDirBy(ctx echo.Context, fileid string) mvc.Result {
 
 	username := ctx.Request().Header.Get("username")
-	password := utils.GetValueWithDefault(ctx, "password", "")
+	password := filePassword(ctx)
 	page := utils.GetIntValueWithDefault(ctx, "page", 1)
 	count := utils.GetIntValueWithDefault(ctx, "count", 50)
 
@@ -61,7 +74,7 @@ func (f *FileController) GetDir(ctx echo.Context) mvc.Result {
 
 	path := utils.GetValueWithDefault(ctx, "path", "/")
 	username := ctx.Request().Header.Get("username")
-	password := utils.GetValueWithDefault(ctx, "password", "")
+	password := filePassword(ctx)
 	page := utils.GetIntValueWithDefault(ctx, "page", 1)
 	count := utils.GetIntValueWithDefault(ctx, "count", 50)
 
@@ -97,3 +110,11 @@ func (f *FileController) PostSearch(ctx echo.Context) mvc.Result {
 
 	return HandleData(result, nil)
 }
+
+// filePassword 优先使用解锁令牌，兼容直接传递密码的旧版本客户端
+func filePassword(ctx echo.Context) string {
+	if token := utils.GetValueWithDefault(ctx, "unlock", ""); token != "" {
+		return token
+	}
+	return utils.GetValueWithDefault(ctx, "password", "")
+}
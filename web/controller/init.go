@@ -1,15 +1,13 @@
 package controller
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/labstack/echo/v4"
 	"github.com/lixiaofei123/nextlist/configs"
+	"github.com/lixiaofei123/nextlist/database"
 	"github.com/lixiaofei123/nextlist/driver"
 	mvc "github.com/lixiaofei123/nextlist/web/mvc"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 )
 
 type InitController struct {
@@ -23,14 +21,24 @@ func (c *InitController) PostConfig(ctx echo.Context, config configs.Config) mvc
 
 	// 先检查配置
 
-	err := checkDb(&config.DataBase.Mysql)
+	err := database.Check(&config.DataBase)
 	if err != nil {
 		return HandleData(nil, err)
 	}
 
-	err = checkDriver(&config.DriverConfig)
-	if err != nil {
-		return HandleData(nil, err)
+	// 配置了挂载点时，driver中的驱动是可选的，它会被挂载在根目录下
+	if len(config.Mounts) == 0 || config.DriverConfig.Name != "" {
+		err = checkDriver(&config.DriverConfig)
+		if err != nil {
+			return HandleData(nil, err)
+		}
+	}
+
+	for _, mount := range config.Mounts {
+		err = checkDriver(&mount.DriverConfig)
+		if err != nil {
+			return HandleData(nil, err)
+		}
 	}
 
 	// 最后写入配置文件
@@ -44,7 +52,21 @@ func (c *InitController) PostConfig(ctx echo.Context, config configs.Config) mvc
 
 func (c *InitController) PostCheckDb(ctx echo.Context, dbconfig configs.Mysql) mvc.Result {
 
-	err := checkDb(&dbconfig)
+	err := database.Check(&configs.DataBase{
+		Type:  database.MYSQL,
+		Mysql: dbconfig,
+	})
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData("ok", nil)
+}
+
+// 检查任意类型的数据库配置，type为mysql、sqlite或者postgres
+func (c *InitController) PostCheckDatabase(ctx echo.Context, dbconfig configs.DataBase) mvc.Result {
+
+	err := database.Check(&dbconfig)
 	if err != nil {
 		return HandleData(nil, err)
 	}
@@ -89,23 +111,3 @@ func checkDriver(driverConfig *configs.DriverConfig) error {
 
 	return nil
 }
-
-func checkDb(dbconfig *configs.Mysql) error {
-	db, err := gorm.Open(mysql.Open(fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
-		dbconfig.Username,
-		dbconfig.Password,
-		dbconfig.Url,
-		dbconfig.Port,
-		dbconfig.Database,
-	)))
-
-	if err != nil {
-		return err
-	}
-
-	err = db.Exec("select 1").Error
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"github.com/labstack/echo/v4"
+	services "github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/utils"
+	mvc "github.com/lixiaofei123/nextlist/web/mvc"
+)
+
+type ShareController struct {
+	shareSrv services.ShareService
+}
+
+func NewShareController(shareSrv services.ShareService) *ShareController {
+	return &ShareController{
+		shareSrv: shareSrv,
+	}
+}
+
+func (s *ShareController) GetBy(ctx echo.Context, shareid string) mvc.Result {
+
+	password := utils.GetValueWithDefault(ctx, "password", "")
+
+	share, err := s.shareSrv.FindShare(shareid, password)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(share, nil)
+}
+
+func (s *ShareController) GetDirBy(ctx echo.Context, shareid string) mvc.Result {
+
+	password := utils.GetValueWithDefault(ctx, "password", "")
+	fileId := utils.GetValueWithDefault(ctx, "fileId", "")
+	page := utils.GetIntValueWithDefault(ctx, "page", 1)
+	count := utils.GetIntValueWithDefault(ctx, "count", 50)
+
+	result, err := s.shareSrv.ListShareFiles(shareid, password, fileId, page, count)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(result, nil)
+}
+
+func (s *ShareController) GetDownloadBy(ctx echo.Context, shareid string) mvc.Result {
+
+	password := utils.GetValueWithDefault(ctx, "password", "")
+	fileId := utils.GetValueWithDefault(ctx, "fileId", "")
+
+	file, err := s.shareSrv.DownloadShareFile(shareid, password, fileId)
+	if err != nil {
+		return HandleData(nil, err)
+	}
+
+	return HandleData(file, nil)
+}
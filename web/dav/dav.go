@@ -0,0 +1,431 @@
+package dav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/driver"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/web/middleware"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	// credentialTTL 校验通过的Basic认证信息缓存的时间，修改密码之后旧密码最多在这段时间内仍然可以使用
+	credentialTTL = 2 * time.Minute
+	// maxCredentials 缓存的认证信息的最大数量
+	maxCredentials = 1000
+)
+
+// Server 以WebDAV协议提供NextList中的文件，可以直接在Finder、资源管理器或者rclone中挂载
+type Server struct {
+	prefix  string
+	fs      *fileSystem
+	userSrv services.UserService
+	handler *webdav.Handler
+
+	// WebDAV客户端的每个请求都会携带密码，缓存校验的结果，避免每个请求都计算一次bcrypt
+	lock        sync.Mutex
+	credentials map[string]time.Time
+}
+
+func New(prefix string, fileSrv services.FileService, userSrv services.UserService, sdriver driver.Driver) *Server {
+
+	prefix = strings.TrimRight(prefix, "/")
+
+	fs := &fileSystem{
+		fileSrv: fileSrv,
+		driver:  sdriver,
+	}
+
+	return &Server{
+		prefix:      prefix,
+		fs:          fs,
+		userSrv:     userSrv,
+		credentials: map[string]time.Time{},
+		handler: &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: fs,
+			LockSystem: webdav.NewMemLS(),
+		},
+	}
+}
+
+// Handler 需要通过echo.Pre注册，echo的路由不支持PROPFIND以外的WebDAV方法
+func (s *Server) Handler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		path := ctx.Request().URL.Path
+		if path != s.prefix && !strings.HasPrefix(path, s.prefix+"/") {
+			return next(ctx)
+		}
+
+		s.ServeHTTP(ctx.Response(), ctx.Request())
+		return nil
+	}
+}
+
+func isReadMethod(method string) bool {
+	return method == "GET" || method == "HEAD" || method == "OPTIONS" || method == "PROPFIND"
+}
+
+// requiredRole 与管理接口中对应的操作需要相同的角色，上传、新建文件夹、移动以及复制需要管理员权限，
+// 同一个文件夹下的MOVE只是重命名，和删除一样登录即可
+func (s *Server) requiredRole(r *http.Request) models.Role {
+
+	switch r.Method {
+	case "PUT", "MKCOL", "COPY":
+		return models.AdminRole
+	case "MOVE":
+		dst, ok := s.destinationPath(r)
+		if ok && path.Dir(dst) == path.Dir(path.Clean("/"+s.requestPath(r))) {
+			return models.UserRole
+		}
+		return models.AdminRole
+	}
+
+	if isReadMethod(r.Method) {
+		return ""
+	}
+
+	return models.UserRole
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	user, ok := s.authenticate(r)
+	if !ok || (user == nil && !isReadMethod(r.Method)) {
+		unauthorized(w)
+		return
+	}
+
+	username := ""
+	if user != nil {
+		username = user.UserName
+		// 角色从数据库中读取，修改后立即生效
+		if role := s.requiredRole(r); role != "" && !user.Role.Includes(role) {
+			http.Error(w, fileerr.ErrNotEnoughPermission.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	password := r.Header.Get("password")
+	if password == "" {
+		password = r.URL.Query().Get("password")
+	}
+
+	ctx := r.Context()
+	ctx = context.WithValue(ctx, usernameKey, username)
+	ctx = context.WithValue(ctx, passwordKey, password)
+	ctx = context.WithValue(ctx, contentLengthKey, r.ContentLength)
+	r = r.WithContext(ctx)
+
+	if err := s.checkPermission(r); err != nil {
+		if username == "" {
+			unauthorized(w)
+		} else {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+		return
+	}
+
+	if r.Method == "COPY" {
+		s.serveCopy(w, r)
+		return
+	}
+
+	if r.Method == "DELETE" {
+		s.serveDelete(w, r)
+		return
+	}
+
+	if r.Method == "GET" || r.Method == "HEAD" {
+		if s.serveFile(w, r) {
+			return
+		}
+	}
+
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Server) requestPath(r *http.Request) string {
+	return strings.TrimPrefix(r.URL.Path, s.prefix)
+}
+
+// checkPermission 提前检查访问权限，webdav.Handler会把权限错误转换为其它的状态码
+func (s *Server) checkPermission(r *http.Request) error {
+
+	name := s.requestPath(r)
+	_, err := s.fs.stat(r.Context(), name)
+	if errors.Is(err, os.ErrNotExist) && (r.Method == "PUT" || r.Method == "MKCOL") {
+		_, err = s.fs.stat(r.Context(), path.Dir(path.Clean("/"+name)))
+	}
+
+	if errors.Is(err, os.ErrPermission) {
+		return err
+	}
+
+	return nil
+}
+
+// authenticate 支持Basic认证以及登录接口返回的token，未携带认证信息时以匿名身份访问，返回的用户为nil
+func (s *Server) authenticate(r *http.Request) (*models.User, bool) {
+
+	if username, password, ok := r.BasicAuth(); ok {
+		user, err := s.login(username, password)
+		if err != nil {
+			return nil, false
+		}
+		return user, true
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		return nil, true
+	}
+
+	claims, ok := middleware.ParseToken(strings.TrimPrefix(authorization, "Bearer "))
+	if !ok {
+		return nil, false
+	}
+
+	// 已经被删除或者禁用的用户签发过的token不能再使用
+	user, err := s.userSrv.FindByName(claims.Issuer)
+	if err != nil || user.Disabled() {
+		return nil, false
+	}
+
+	return user, true
+}
+
+// login 校验Basic认证的用户名和密码，缓存中只保存用户名和密码的哈希。
+// 命中缓存时仍然会从数据库中读取用户，被删除或者禁用的用户立即失效
+func (s *Server) login(username, password string) (*models.User, error) {
+
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	key := hex.EncodeToString(sum[:])
+
+	s.lock.Lock()
+	expireAt, ok := s.credentials[key]
+	s.lock.Unlock()
+
+	if ok && expireAt.After(time.Now()) {
+		user, err := s.userSrv.FindByName(username)
+		if err != nil {
+			return nil, err
+		}
+		if user.Disabled() {
+			return nil, fileerr.ErrUserDisabled
+		}
+		return user, nil
+	}
+
+	user, err := s.userSrv.Login(username, password, services.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.credentials) >= maxCredentials {
+		for key, expireAt := range s.credentials {
+			if !expireAt.After(now) {
+				delete(s.credentials, key)
+			}
+		}
+		if len(s.credentials) >= maxCredentials {
+			s.credentials = map[string]time.Time{}
+		}
+	}
+	s.credentials[key] = now.Add(credentialTTL)
+
+	return user, nil
+}
+
+// serveFile 文件的下载直接跳转到存储驱动的下载链接
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) bool {
+
+	file, err := s.fs.stat(r.Context(), s.requestPath(r))
+	if err != nil || file.IsDict.Bool {
+		return false
+	}
+
+	if r.Method == "HEAD" {
+		fi := &fileInfo{file: file}
+		ctype, _ := fi.ContentType(r.Context())
+
+		w.Header().Set("Content-Type", ctype)
+		w.Header().Set("Last-Modified", file.LastModifyTime.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", file.FileSize))
+		w.WriteHeader(http.StatusOK)
+		return true
+	}
+
+	if len(file.DownloadUrls) == 0 {
+		http.Error(w, "没有可用的下载链接", http.StatusNotFound)
+		return true
+	}
+
+	http.Redirect(w, r, file.DownloadUrls[0].DownloadUrl, http.StatusFound)
+	return true
+}
+
+// destinationPath 返回MOVE以及COPY的目标地址，目标地址不在当前服务中时返回false
+func (s *Server) destinationPath(r *http.Request) (string, bool) {
+
+	destination, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || (destination.Host != "" && destination.Host != r.Host) {
+		return "", false
+	}
+
+	if destination.Path != s.prefix && !strings.HasPrefix(destination.Path, s.prefix+"/") {
+		return "", false
+	}
+
+	return path.Clean("/" + strings.TrimPrefix(destination.Path, s.prefix)), true
+}
+
+// serveCopy webdav.Handler会通过读写文件内容来完成复制，这里改为由存储驱动在服务端复制
+func (s *Server) serveCopy(w http.ResponseWriter, r *http.Request) {
+
+	dst, ok := s.destinationPath(r)
+	if !ok {
+		http.Error(w, "错误的目标地址", http.StatusBadGateway)
+		return
+	}
+
+	ctx := r.Context()
+	src := path.Clean("/" + s.requestPath(r))
+	if src == dst {
+		http.Error(w, "目标地址与源地址相同", http.StatusForbidden)
+		return
+	}
+
+	if _, err := s.fs.stat(ctx, src); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	exists := false
+	dstFile, err := s.fs.stat(ctx, dst)
+	if err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			http.Error(w, "目标文件已经存在", http.StatusPreconditionFailed)
+			return
+		}
+		exists = true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		writeError(w, err)
+		return
+	}
+
+	// 目标已经存在时先复制到一个临时的名字，复制成功之后再替换，复制失败时目标不受影响
+	target := dst
+	if exists {
+		target = path.Join(path.Dir(dst), fmt.Sprintf(".%s.%s", path.Base(dst), uuid.NewString()[:8]))
+	}
+
+	if err := s.fs.Copy(ctx, src, target); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// 目标文件夹不存在
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	if !exists {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	// 被覆盖的目标放入回收站
+	if err := s.fs.RemoveAll(ctx, dst); err != nil {
+		writeCopyError(w, err, s.rollbackCopy(ctx, target, nil))
+		return
+	}
+
+	if err := s.fs.Rename(ctx, target, dst); err != nil {
+		writeCopyError(w, err, s.rollbackCopy(ctx, target, dstFile))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// rollbackCopy 删除复制出来的临时文件，replaced不为空时还原已经放入回收站的目标，返回回滚失败的原因
+func (s *Server) rollbackCopy(ctx context.Context, target string, replaced *models.File) []string {
+
+	failures := []string{}
+
+	if replaced != nil {
+		if _, err := s.fs.fileSrv.RestoreFile(getString(ctx, usernameKey), replaced.ID); err != nil {
+			log.Printf("还原被覆盖的文件%s失败: %s", replaced.AbsolutePath, err)
+			failures = append(failures, fmt.Sprintf("还原%s失败: %s", replaced.AbsolutePath, err))
+		}
+	}
+
+	if err := s.fs.purge(ctx, target); err != nil {
+		log.Printf("删除复制出来的临时文件%s失败: %s", target, err)
+		failures = append(failures, fmt.Sprintf("删除临时文件%s失败: %s", target, err))
+	}
+
+	return failures
+}
+
+// writeCopyError 回滚没有完成时目标可能已经被修改，需要让客户端知道
+func writeCopyError(w http.ResponseWriter, err error, failures []string) {
+
+	if len(failures) == 0 {
+		writeError(w, err)
+		return
+	}
+
+	http.Error(w, fmt.Sprintf("复制失败: %s，并且回滚没有完成: %s", err, strings.Join(failures, "; ")), http.StatusInternalServerError)
+}
+
+// serveDelete webdav.Handler删除失败时总是返回405，这里根据错误返回对应的状态码
+func (s *Server) serveDelete(w http.ResponseWriter, r *http.Request) {
+
+	if err := s.fs.RemoveAll(r.Context(), s.requestPath(r)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, os.ErrPermission):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, os.ErrExist):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="NextList"`)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
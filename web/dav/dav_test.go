@@ -0,0 +1,322 @@
+package dav
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/configs"
+	"github.com/lixiaofei123/nextlist/database"
+	"github.com/lixiaofei123/nextlist/driver"
+	"github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/services"
+)
+
+const testPassword = "password123"
+
+type testServer struct {
+	url     string
+	root    string
+	fileSrv services.FileService
+}
+
+// newTestServer 启动一个使用本地存储的服务，上传需要通过驱动的上传接口，因此需要真正地监听端口。
+// 第一个注册的用户superadmin是超级管理员，normaluser是普通用户
+func newTestServer(t *testing.T) *testServer {
+
+	db, err := database.Open(&configs.DataBase{
+		Type:   database.SQLITE,
+		Sqlite: configs.Sqlite{Path: filepath.Join(t.TempDir(), "nextlist.db")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+
+	root := t.TempDir()
+	sdriver, err := driver.GetDriver("file", map[string]interface{}{
+		"path": root,
+		"key":  "nextlist",
+		"host": server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sdriver.InitDriver(e.Group("/api/v1"), db); err != nil {
+		t.Fatal(err)
+	}
+
+	fileSrv := services.NewFileService(db, sdriver)
+	userSrv := services.NewUserService(db, fileSrv)
+	for i, username := range []string{"superadmin", "normaluser"} {
+		if _, err := userSrv.Register(&models.User{
+			UserName: username,
+			ShowName: username,
+			Email:    username + "@nextlist.com",
+			Tel:      []string{"13800000000", "13800000001"}[i],
+			Password: testPassword,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e.Pre(New("/dav", fileSrv, userSrv, sdriver).Handler)
+
+	return &testServer{url: server.URL, root: root, fileSrv: fileSrv}
+}
+
+func (s *testServer) do(t *testing.T, username, method, name string, body io.Reader, headers map[string]string) *http.Response {
+
+	req, err := http.NewRequest(method, s.url+"/dav"+name, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "" {
+		req.SetBasicAuth(username, testPassword)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func (s *testServer) put(t *testing.T, name, data string) {
+	if resp := s.do(t, "superadmin", "PUT", name, strings.NewReader(data), nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected put %s %d", name, resp.StatusCode)
+	}
+}
+
+func (s *testServer) read(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(s.root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func Test_PutAndPropfind(t *testing.T) {
+
+	s := newTestServer(t)
+
+	s.put(t, "/a.txt", "abc")
+
+	// 上传完成后文件已经被确认
+	file, err := s.fileSrv.FindByPath("/a.txt")
+	if err != nil || file.FileStatus != models.SUCCESS || file.FileSize != 3 {
+		t.Fatalf("unexpected file %+v %v", file, err)
+	}
+	if s.read(t, "a.txt") != "abc" {
+		t.Fatal("unexpected data")
+	}
+
+	resp := s.do(t, "", "PROPFIND", "/", nil, map[string]string{"Depth": "1"})
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(string(data), "/dav/a.txt") {
+		t.Fatalf("unexpected propfind %d %s", resp.StatusCode, data)
+	}
+
+	resp = s.do(t, "", "GET", "/a.txt", nil, nil)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") == "" {
+		t.Fatalf("unexpected get %d", resp.StatusCode)
+	}
+}
+
+func Test_RejectWrites(t *testing.T) {
+
+	s := newTestServer(t)
+
+	s.put(t, "/a.txt", "abc")
+
+	// 匿名用户不能写入
+	for _, method := range []string{"PUT", "MKCOL", "DELETE", "PROPPATCH"} {
+		if resp := s.do(t, "", method, "/b.txt", strings.NewReader("abc"), nil); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("unexpected %s %d", method, resp.StatusCode)
+		}
+	}
+
+	// 上传、新建文件夹、复制以及移动到其它文件夹需要管理员权限
+	if resp := s.do(t, "normaluser", "PUT", "/b.txt", strings.NewReader("abc"), nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected put %d", resp.StatusCode)
+	}
+	if resp := s.do(t, "normaluser", "MKCOL", "/docs", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected mkcol %d", resp.StatusCode)
+	}
+	if resp := s.do(t, "normaluser", "COPY", "/a.txt", nil, map[string]string{"Destination": s.url + "/dav/b.txt"}); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected copy %d", resp.StatusCode)
+	}
+
+	if resp := s.do(t, "superadmin", "MKCOL", "/docs", nil, nil); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected mkcol %d", resp.StatusCode)
+	}
+	if resp := s.do(t, "normaluser", "MOVE", "/a.txt", nil, map[string]string{"Destination": s.url + "/dav/docs/a.txt"}); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected move %d", resp.StatusCode)
+	}
+
+	// 错误的密码不会命中缓存
+	req, _ := http.NewRequest("PROPFIND", s.url+"/dav/", nil)
+	req.SetBasicAuth("superadmin", "wrongpassword")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected propfind %d", resp.StatusCode)
+	}
+}
+
+func Test_MoveAndCopy(t *testing.T) {
+
+	s := newTestServer(t)
+
+	s.put(t, "/a.txt", "abc")
+	s.put(t, "/b.txt", "xyz")
+
+	destination := map[string]string{"Destination": s.url + "/dav/b.txt", "Overwrite": "F"}
+	if resp := s.do(t, "superadmin", "COPY", "/a.txt", nil, destination); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("unexpected copy %d", resp.StatusCode)
+	}
+	if s.read(t, "b.txt") != "xyz" {
+		t.Fatal("destination should not be changed")
+	}
+
+	destination["Overwrite"] = "T"
+	if resp := s.do(t, "superadmin", "COPY", "/a.txt", nil, destination); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected copy %d", resp.StatusCode)
+	}
+	if s.read(t, "b.txt") != "abc" || s.read(t, "a.txt") != "abc" {
+		t.Fatal("unexpected data after copy")
+	}
+
+	// 覆盖失败时目标不受影响
+	if resp := s.do(t, "superadmin", "COPY", "/missing.txt", nil, destination); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected copy %d", resp.StatusCode)
+	}
+	if _, err := s.fileSrv.FindByPath("/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	destination = map[string]string{"Destination": s.url + "/dav/c.txt"}
+	if resp := s.do(t, "superadmin", "MOVE", "/a.txt", nil, destination); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("unexpected move %d", resp.StatusCode)
+	}
+	if s.read(t, "c.txt") != "abc" {
+		t.Fatal("unexpected data after move")
+	}
+	if _, err := s.fileSrv.FindByPath("/a.txt"); err == nil {
+		t.Fatal("source should be moved")
+	}
+
+	destination = map[string]string{"Destination": s.url + "/dav/b.txt", "Overwrite": "F"}
+	if resp := s.do(t, "superadmin", "MOVE", "/c.txt", nil, destination); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("unexpected move %d", resp.StatusCode)
+	}
+}
+
+func Test_DeleteFolder(t *testing.T) {
+
+	s := newTestServer(t)
+
+	for _, name := range []string{"/docs", "/docs/sub"} {
+		if resp := s.do(t, "superadmin", "MKCOL", name, nil, nil); resp.StatusCode != http.StatusCreated {
+			t.Fatalf("unexpected mkcol %d", resp.StatusCode)
+		}
+	}
+	s.put(t, "/docs/a.txt", "abc")
+	s.put(t, "/docs/sub/b.txt", "xyz")
+
+	// 不能删除别人的文件夹
+	if resp := s.do(t, "normaluser", "DELETE", "/docs", nil, nil); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected delete %d", resp.StatusCode)
+	}
+
+	// 不为空的文件夹整个放入回收站
+	if resp := s.do(t, "superadmin", "DELETE", "/docs", nil, nil); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected delete %d", resp.StatusCode)
+	}
+	for _, name := range []string{"/docs", "/docs/a.txt", "/docs/sub/b.txt"} {
+		if _, err := s.fileSrv.FindByPath(name); err == nil {
+			t.Fatalf("%s should be in trash", name)
+		}
+	}
+
+	result, err := s.fileSrv.ListTrash("superadmin", 1, 10)
+	if err != nil || result.Total != 1 {
+		t.Fatalf("unexpected trash %+v %v", result, err)
+	}
+
+	dir := result.List.([]*models.File)[0]
+	if _, err := s.fileSrv.RestoreFile("superadmin", dir.ID); err != nil {
+		t.Fatal(err)
+	}
+	if s.read(t, "docs/a.txt") != "abc" || s.read(t, "docs/sub/b.txt") != "xyz" {
+		t.Fatal("unexpected data after restore")
+	}
+}
+
+func Test_OverwritePut(t *testing.T) {
+
+	s := newTestServer(t)
+
+	s.put(t, "/a.txt", "abc")
+	if resp := s.do(t, "superadmin", "PUT", "/a.txt", strings.NewReader("xyzw"), nil); resp.StatusCode/100 != 2 {
+		t.Fatalf("unexpected put %d", resp.StatusCode)
+	}
+	if s.read(t, "a.txt") != "xyzw" {
+		t.Fatal("unexpected data after overwrite")
+	}
+
+	// 上传中途断开时原来的文件不受影响
+	conn, err := net.Dial("tcp", strings.TrimPrefix(s.url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	auth := base64.StdEncoding.EncodeToString([]byte("superadmin:" + testPassword))
+	if _, err := io.WriteString(conn, "PUT /dav/a.txt HTTP/1.1\r\nHost: nextlist\r\nAuthorization: Basic "+auth+
+		"\r\nContent-Length: 10\r\n\r\nab"); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	ioutil.ReadAll(conn)
+
+	if s.read(t, "a.txt") != "xyzw" {
+		t.Fatal("original file should be kept")
+	}
+	file, err := s.fileSrv.FindByPath("/a.txt")
+	if err != nil || file.FileSize != 4 {
+		t.Fatalf("unexpected file %+v %v", file, err)
+	}
+
+	// 临时文件已经被删除
+	entries, err := ioutil.ReadDir(s.root)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected entries %v %v", entries, err)
+	}
+}
@@ -0,0 +1,580 @@
+package dav
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lixiaofei123/nextlist/driver"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/utils"
+	"golang.org/x/net/webdav"
+)
+
+type contextKey string
+
+const (
+	usernameKey      contextKey = "username"
+	passwordKey      contextKey = "password"
+	contentLengthKey contextKey = "contentLength"
+)
+
+func getString(ctx context.Context, key contextKey) string {
+	value, _ := ctx.Value(key).(string)
+	return value
+}
+
+// fileSystem 将数据库中的文件树映射为webdav.FileSystem，文件内容的读写交给存储驱动
+type fileSystem struct {
+	fileSrv services.FileService
+	driver  driver.Driver
+}
+
+func toOsError(err error) error {
+	switch {
+	case errors.Is(err, fileerr.ErrFileNotFound):
+		return os.ErrNotExist
+	case errors.Is(err, fileerr.ErrNotEnoughPermission), errors.Is(err, fileerr.ErrPasswordIsWrong), errors.Is(err, fileerr.ErrNeedLogin),
+		errors.Is(err, fileerr.ErrContainsOthersFiles):
+		return os.ErrPermission
+	case errors.Is(err, fileerr.ErrFileExists), errors.Is(err, fileerr.ErrCreateDirConflict):
+		return os.ErrExist
+	}
+	return err
+}
+
+func (fs *fileSystem) stat(ctx context.Context, name string) (*models.File, error) {
+
+	name = utils.ParsePath(path.Clean("/" + name))
+	if name == "/" {
+		return &models.File{
+			Name:         "/",
+			AbsolutePath: "/",
+			IsDict:       sql.NullBool{Valid: true, Bool: true},
+			Permission:   models.PUBLICREAD,
+		}, nil
+	}
+
+	file, err := fs.fileSrv.FindByPath(name)
+	if err != nil {
+		return nil, toOsError(err)
+	}
+
+	file, err = fs.fileSrv.FindById(getString(ctx, usernameKey), getString(ctx, passwordKey), file.ID)
+	if err != nil {
+		return nil, toOsError(err)
+	}
+
+	return file, nil
+}
+
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+
+	file, err := fs.stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileInfo{file: file}, nil
+}
+
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+
+	username := getString(ctx, usernameKey)
+	if username == "" {
+		return os.ErrPermission
+	}
+
+	parent, err := fs.stat(ctx, path.Dir(name))
+	if err != nil {
+		return err
+	}
+
+	_, err = fs.fileSrv.CreateDictory(username, parent.ID, path.Base(name), models.PUBLICREAD, "")
+	return toOsError(err)
+}
+
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+
+	username := getString(ctx, usernameKey)
+	if username == "" {
+		return os.ErrPermission
+	}
+
+	file, err := fs.stat(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if file.ID == "" {
+		return os.ErrPermission
+	}
+
+	// 文件夹以及其中的文件会一起被放入回收站，存储中的文件在清理回收站时才会删除
+	_, err = fs.fileSrv.TrashFile(username, file.ID)
+	return toOsError(err)
+}
+
+// purge 彻底删除文件(夹)以及存储中的文件，用于清理没有完成的操作留下的文件
+func (fs *fileSystem) purge(ctx context.Context, name string) error {
+
+	file, err := fs.stat(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	if file.ID == "" {
+		return os.ErrPermission
+	}
+
+	report, err := fs.fileSrv.DeleteFileRecursive(getString(ctx, usernameKey), file.ID)
+	if err != nil {
+		return toOsError(err)
+	}
+
+	if len(report.Failures) > 0 {
+		return fmt.Errorf("删除%s失败: %s", report.Failures[0].AbsolutePath, report.Failures[0].Reason)
+	}
+
+	return nil
+}
+
+func (fs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+
+	username := getString(ctx, usernameKey)
+	if username == "" {
+		return os.ErrPermission
+	}
+
+	file, err := fs.stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+
+	if file.ID == "" {
+		return os.ErrPermission
+	}
+
+	parent, err := fs.stat(ctx, path.Dir(path.Clean("/"+newName)))
+	if err != nil {
+		return err
+	}
+
+	_, err = fs.fileSrv.MoveFile(username, file.ID, parent.ID, path.Base(newName))
+	return toOsError(err)
+}
+
+// Copy 复制文件(夹)，由存储驱动在服务端完成复制，不经过NextList中转文件内容
+func (fs *fileSystem) Copy(ctx context.Context, oldName, newName string) error {
+
+	username := getString(ctx, usernameKey)
+	if username == "" {
+		return os.ErrPermission
+	}
+
+	file, err := fs.stat(ctx, oldName)
+	if err != nil {
+		return err
+	}
+
+	if file.ID == "" {
+		return os.ErrPermission
+	}
+
+	parent, err := fs.stat(ctx, path.Dir(path.Clean("/"+newName)))
+	if err != nil {
+		return err
+	}
+
+	_, err = fs.fileSrv.CopyFile(username, file.ID, parent.ID, path.Base(newName))
+	return toOsError(err)
+}
+
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) == 0 {
+		file, err := fs.stat(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		return &readFile{ctx: ctx, fs: fs, file: file}, nil
+	}
+
+	return fs.openUpload(ctx, name, flag)
+}
+
+func (fs *fileSystem) openUpload(ctx context.Context, name string, flag int) (webdav.File, error) {
+
+	username := getString(ctx, usernameKey)
+	if username == "" {
+		return nil, os.ErrPermission
+	}
+
+	name = utils.ParsePath(path.Clean("/" + name))
+
+	parent, err := fs.stat(ctx, path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsDict.Bool {
+		return nil, os.ErrNotExist
+	}
+
+	isNew := false
+	file, err := fs.stat(ctx, name)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+
+		contentLength, _ := ctx.Value(contentLengthKey).(int64)
+		if contentLength < 0 {
+			contentLength = 0
+		}
+
+		file, err = fs.fileSrv.PreSaveFile(username, &models.File{
+			ParentId: parent.ID,
+			Name:     path.Base(name),
+			FileSize: contentLength,
+			FileType: utils.FindMimetypeByExt(filepath.Ext(name)),
+		})
+		if err != nil {
+			return nil, toOsError(err)
+		}
+		isNew = true
+	} else if file.IsDict.Bool {
+		return nil, os.ErrExist
+	} else if file.UserName != username {
+		return nil, os.ErrPermission
+	}
+
+	upload, err := fs.newUploadFile(ctx, file, isNew)
+	if err != nil {
+		if isNew {
+			fs.fileSrv.DeleteFile(username, file.ID)
+		}
+		return nil, err
+	}
+
+	return upload, nil
+}
+
+type fileInfo struct {
+	file *models.File
+}
+
+func (fi *fileInfo) Name() string {
+	return fi.file.Name
+}
+
+func (fi *fileInfo) Size() int64 {
+	return fi.file.FileSize
+}
+
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.file.IsDict.Bool {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.file.LastModifyTime
+}
+
+func (fi *fileInfo) IsDir() bool {
+	return fi.file.IsDict.Bool
+}
+
+func (fi *fileInfo) Sys() interface{} {
+	return fi.file
+}
+
+// ContentType 避免webdav为了推断类型而读取文件内容
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.file.FileType != "" {
+		return fi.file.FileType, nil
+	}
+	if mtype := utils.FindMimetypeByExt(filepath.Ext(fi.file.Name)); mtype != "" {
+		return mtype, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// readFile 用于列目录以及获取文件信息，文件内容需要通过驱动的下载链接获取
+type readFile struct {
+	ctx      context.Context
+	fs       *fileSystem
+	file     *models.File
+	children []os.FileInfo
+	loaded   bool
+}
+
+func (f *readFile) Close() error {
+	return nil
+}
+
+func (f *readFile) Read(p []byte) (int, error) {
+	return 0, fileerr.ErrUnSupportOperation
+}
+
+func (f *readFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, fileerr.ErrUnSupportOperation
+}
+
+func (f *readFile) Write(p []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *readFile) Stat() (os.FileInfo, error) {
+	return &fileInfo{file: f.file}, nil
+}
+
+func (f *readFile) Readdir(count int) ([]os.FileInfo, error) {
+
+	if !f.file.IsDict.Bool {
+		return nil, fileerr.ErrNotDirectoy
+	}
+
+	if !f.loaded {
+		username := getString(f.ctx, usernameKey)
+		password := getString(f.ctx, passwordKey)
+		pageCount := 50
+		for page := 1; ; page++ {
+			result, err := f.fs.fileSrv.FindChildFiles(username, f.file.ID, password, page, pageCount)
+			if err != nil {
+				return nil, toOsError(err)
+			}
+			files, _ := result.List.([]*models.File)
+			for _, file := range files {
+				f.children = append(f.children, &fileInfo{file: file})
+			}
+			if len(files) < pageCount || len(f.children) >= result.Total {
+				break
+			}
+		}
+		f.loaded = true
+	}
+
+	if count <= 0 {
+		children := f.children
+		f.children = nil
+		return children, nil
+	}
+
+	if len(f.children) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > len(f.children) {
+		count = len(f.children)
+	}
+	children := f.children[:count]
+	f.children = f.children[count:]
+	return children, nil
+}
+
+// uploadFile 将写入的数据直接写入存储，驱动不支持直接写入时转发到驱动的上传链接，长度未知时先写入临时文件
+type uploadFile struct {
+	ctx   context.Context
+	fs    *fileSystem
+	file  *models.File
+	isNew bool
+	// key 是数据写入的位置，覆盖已有的文件时是一个临时的位置
+	key      string
+	putter   driver.Putter
+	size     int64
+	length   int64
+	writer   io.WriteCloser
+	tempFile *os.File
+	done     chan error
+}
+
+func (fs *fileSystem) newUploadFile(ctx context.Context, file *models.File, isNew bool) (*uploadFile, error) {
+
+	upload := &uploadFile{
+		ctx:   ctx,
+		fs:    fs,
+		file:  file,
+		isNew: isNew,
+		key:   file.AbsolutePath,
+	}
+
+	// 覆盖已有的文件时先写入临时的位置，上传成功之后再移动过去，上传失败时原来的文件不受影响
+	if d, _, err := driver.Resolve(fs.driver, file.AbsolutePath); err == nil {
+		if _, ok := d.(driver.Putter); ok {
+			upload.putter, _ = fs.driver.(driver.Putter)
+		}
+		if _, ok := d.(driver.Mover); ok && !isNew {
+			upload.key = path.Join(path.Dir(file.AbsolutePath), fmt.Sprintf(".%s.%s.uploading", file.Name, uuid.NewString()[:8]))
+		}
+	}
+
+	contentLength, ok := ctx.Value(contentLengthKey).(int64)
+	if !ok || contentLength < 0 {
+		tempFile, err := ioutil.TempFile("", "nextlist-dav-")
+		if err != nil {
+			return nil, err
+		}
+		upload.tempFile = tempFile
+		upload.writer = tempFile
+		return upload, nil
+	}
+	upload.length = contentLength
+
+	reader, writer := io.Pipe()
+	upload.writer = writer
+	upload.done = make(chan error, 1)
+
+	go func() {
+		err := upload.put(reader, contentLength)
+		reader.CloseWithError(err)
+		upload.done <- err
+	}()
+
+	return upload, nil
+}
+
+func (f *uploadFile) put(body io.Reader, contentLength int64) error {
+
+	if f.putter != nil {
+		return f.putter.Put(f.key, body, contentLength)
+	}
+
+	uploadUrl, err := f.fs.driver.PreUploadUrl(f.key)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequest("PUT", uploadUrl, body)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = contentLength
+	if contentLength == 0 {
+		request.Body = http.NoBody
+	}
+
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("上传文件返回错误返回码%d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (f *uploadFile) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *uploadFile) Close() error {
+
+	err := f.finish()
+	username := getString(f.ctx, usernameKey)
+
+	if err == nil {
+		var file *models.File
+		file, err = f.fs.fileSrv.FinishUpload(username, f.file.ID, f.size)
+		if err == nil {
+			f.file = file
+			return nil
+		}
+	}
+
+	if f.isNew {
+		f.fs.fileSrv.DeleteFile(username, f.file.ID)
+	}
+
+	return err
+}
+
+func (f *uploadFile) finish() error {
+
+	err := f.upload()
+	if f.key == f.file.AbsolutePath {
+		return err
+	}
+
+	if err == nil {
+		err = f.fs.driver.(driver.Mover).Move(f.key, f.file.AbsolutePath)
+	}
+
+	if err != nil {
+		if deleteErr := f.deleteTemp(); deleteErr != nil {
+			log.Printf("删除上传的临时文件%s失败: %s", f.key, deleteErr)
+		}
+	}
+
+	return err
+}
+
+func (f *uploadFile) upload() error {
+
+	if f.tempFile != nil {
+		defer os.Remove(f.tempFile.Name())
+		defer f.tempFile.Close()
+
+		_, err := f.tempFile.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		return f.put(f.tempFile, f.size)
+	}
+
+	// 请求中途断开时写入的数据比声明的长度少，不能当作上传成功
+	if f.size != f.length {
+		f.writer.(*io.PipeWriter).CloseWithError(io.ErrUnexpectedEOF)
+	} else {
+		f.writer.Close()
+	}
+	return <-f.done
+}
+
+// deleteTemp 删除上传失败时留在临时位置的文件
+func (f *uploadFile) deleteTemp() error {
+
+	deleter, ok := f.fs.driver.(driver.Deleter)
+	if !ok {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	return deleter.Delete(f.key)
+}
+
+func (f *uploadFile) Read(p []byte) (int, error) {
+	return 0, fileerr.ErrUnSupportOperation
+}
+
+func (f *uploadFile) Seek(offset int64, whence int) (int64, error) {
+	return 0, fileerr.ErrUnSupportOperation
+}
+
+func (f *uploadFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, fileerr.ErrNotDirectoy
+}
+
+func (f *uploadFile) Stat() (os.FileInfo, error) {
+	file := *f.file
+	file.FileSize = f.size
+	return &fileInfo{file: &file}, nil
+}
@@ -8,27 +8,37 @@ import (
 	"github.com/lixiaofei123/nextlist/configs"
 	fileerr "github.com/lixiaofei123/nextlist/errors"
 	"github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/services"
 	"github.com/lixiaofei123/nextlist/web/controller"
 )
 
+// ParseToken 校验登录时签发的token，校验失败时返回false
+func ParseToken(authorization string) (*models.JWTClaims, bool) {
+
+	token, err := jwt.ParseWithClaims(authorization, &models.JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(configs.GlobalConfig.Auth.Secret), nil
+	})
+	if err != nil || !token.Valid {
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(*models.JWTClaims)
+	return claims, ok
+}
+
 func AuthHandler(next echo.HandlerFunc) echo.HandlerFunc {
 
 	return func(ctx echo.Context) error {
 
 		authorization := ctx.Request().Header.Get("authorization")
-		token, err := jwt.ParseWithClaims(authorization, &models.JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(configs.GlobalConfig.Auth.Secret), nil
-		})
 
-		if err == nil && token.Valid {
-			if claims, ok := token.Claims.(*models.JWTClaims); ok {
-				ctx.Request().Header.Set("email", claims.Email)
-				ctx.Request().Header.Set("role", claims.Role)
-				ctx.Request().Header.Set("username", claims.Issuer)
-				ctx.Request().Header.Set("showname", claims.ShowName)
+		if claims, ok := ParseToken(authorization); ok {
+			ctx.Request().Header.Set("email", claims.Email)
+			ctx.Request().Header.Set("role", claims.Role)
+			ctx.Request().Header.Set("username", claims.Issuer)
+			ctx.Request().Header.Set("showname", claims.ShowName)
 
-				return next(ctx)
-			}
+			return next(ctx)
 		}
 
 		ctx.JSON(http.StatusUnauthorized, controller.DataResponse{
@@ -40,31 +50,33 @@ func AuthHandler(next echo.HandlerFunc) echo.HandlerFunc {
 
 }
 
-func NotMustAuthHandler(next echo.HandlerFunc) echo.HandlerFunc {
+// NotMustAuthHandler 登录是可选的，没有登录、token无效以及用户已经被删除或者禁用时按照匿名用户处理。
+// 用户的信息和角色从数据库中读取，修改后立即生效，请求中自带的用户信息会被清除
+func NotMustAuthHandler(userSrv services.UserService) echo.MiddlewareFunc {
 
-	return func(ctx echo.Context) error {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
 
-		authorization := ctx.Request().Header.Get("authorization")
-		token, err := jwt.ParseWithClaims(authorization, &models.JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(configs.GlobalConfig.Auth.Secret), nil
-		})
-		if err != nil {
-			return next(ctx)
-		}
-		if !token.Valid {
-			return next(ctx)
-		}
-		if claims, ok := token.Claims.(*models.JWTClaims); ok {
+		return func(ctx echo.Context) error {
 
-			ctx.Request().Header.Set("email", claims.Email)
-			ctx.Request().Header.Set("role", claims.Role)
-			ctx.Request().Header.Set("username", claims.Issuer)
-			ctx.Request().Header.Set("showname", claims.ShowName)
+			header := ctx.Request().Header
+			claims, ok := ParseToken(header.Get("authorization"))
+
+			for _, key := range []string{"email", "role", "username", "showname"} {
+				header.Del(key)
+			}
+
+			if ok {
+				user, err := userSrv.FindByName(claims.Issuer)
+				if err == nil && !user.Disabled() {
+					header.Set("email", user.Email)
+					header.Set("role", string(user.Role))
+					header.Set("username", user.UserName)
+					header.Set("showname", user.ShowName)
+				}
+			}
 
 			return next(ctx)
 		}
-
-		return next(ctx)
 	}
 
 }
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/services"
+	"github.com/lixiaofei123/nextlist/web/controller"
+)
+
+// RoleRequirer 控制器通过这个接口声明每个方法需要的最低角色，返回空字符串时不限制角色
+type RoleRequirer interface {
+	RequiredRole(funcName string) models.Role
+}
+
+// ActiveUserHandler 重新从数据库中读取登录的用户，已经被删除或者禁用的用户即使token没有过期也不能继续访问，
+// 修改后的角色也会立即生效。需要在AuthHandler之后使用
+func ActiveUserHandler(userSrv services.UserService) echo.MiddlewareFunc {
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+
+		return func(ctx echo.Context) error {
+
+			user, err := userSrv.FindByName(ctx.Request().Header.Get("username"))
+			if err == nil && user.Disabled() {
+				err = fileerr.ErrUserDisabled
+			}
+
+			if err != nil {
+				ctx.JSON(http.StatusUnauthorized, controller.DataResponse{
+					Code: http.StatusUnauthorized,
+					Data: err.Error(),
+				})
+				return nil
+			}
+
+			ctx.Request().Header.Set("role", string(user.Role))
+
+			return next(ctx)
+		}
+	}
+
+}
+
+// RoleHandler 只允许拥有role权限的用户访问，需要在AuthHandler之后使用
+func RoleHandler(role models.Role) echo.MiddlewareFunc {
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+
+		return func(ctx echo.Context) error {
+
+			if models.Role(ctx.Request().Header.Get("role")).Includes(role) {
+				return next(ctx)
+			}
+
+			ctx.JSON(http.StatusForbidden, controller.DataResponse{
+				Code: http.StatusForbidden,
+				Data: fileerr.ErrNotEnoughPermission.Error(),
+			})
+			return nil
+		}
+	}
+
+}
+
+// ControllerRole 是一个mvc.MethodMiddleware，按照控制器实现的RoleRequirer为每个方法添加RoleHandler
+func ControllerRole(c interface{}, funcName string) []echo.MiddlewareFunc {
+
+	requirer, ok := c.(RoleRequirer)
+	if !ok {
+		return nil
+	}
+
+	role := requirer.RequiredRole(funcName)
+	if role == "" {
+		return nil
+	}
+
+	return []echo.MiddlewareFunc{RoleHandler(role)}
+}
@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	echomiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/web/controller"
+	"github.com/lixiaofei123/nextlist/web/mvc"
+)
+
+func Test_AdminControllerRoles(t *testing.T) {
+
+	// 角色不够时请求不会到达控制器，因此不需要提供服务，到达控制器时会返回500
+	e := echo.New()
+	e.Use(echomiddleware.Recover())
+	app := mvc.New(e.Group("/admin")).Use(ControllerRole)
+	app.Handle(controller.NewAdminFileController(nil, nil))
+	app.Handle(controller.NewAdminShareController(nil))
+	app.Handle(controller.NewAdminSyncController(nil))
+	app.Handle(controller.NewAdminUploadController(nil))
+	app.Handle(controller.NewAdminUserController(nil))
+
+	tests := []struct {
+		method string
+		path   string
+		role   models.Role
+	}{
+		{"POST", "/admin/driver/sign/upload", models.AdminRole},
+		{"POST", "/admin/driver/sign/delete", models.UserRole},
+		{"PUT", "/admin/dir", models.AdminRole},
+		{"PUT", "/admin/dir/:param0", models.AdminRole},
+		{"POST", "/admin/file", models.AdminRole},
+		{"POST", "/admin/file/:param0", models.AdminRole},
+		{"DELETE", "/admin/file/:param0", models.UserRole},
+		{"GET", "/admin/trash", models.UserRole},
+		{"POST", "/admin/restore/:param0", models.UserRole},
+		{"DELETE", "/admin/trash/:param0", models.UserRole},
+		{"POST", "/admin/move/:param0", models.AdminRole},
+		{"POST", "/admin/rename/:param0", models.UserRole},
+		{"POST", "/admin/copy/:param0", models.AdminRole},
+		{"POST", "/admin/confirm/file/:param0", models.AdminRole},
+		{"GET", "/admin/shares", models.UserRole},
+		{"PUT", "/admin/share/:param0", models.UserRole},
+		{"DELETE", "/admin/share/:param0", models.UserRole},
+		{"POST", "/admin/sync", models.AdminRole},
+		{"GET", "/admin/synctasks", models.AdminRole},
+		{"GET", "/admin/synctask/:param0", models.AdminRole},
+		{"DELETE", "/admin/synctask/:param0", models.AdminRole},
+		{"PUT", "/admin/syncjob", models.AdminRole},
+		{"GET", "/admin/syncjobs", models.AdminRole},
+		{"POST", "/admin/syncjob/:param0", models.AdminRole},
+		{"DELETE", "/admin/syncjob/:param0", models.AdminRole},
+		{"POST", "/admin/syncjob/run/:param0", models.AdminRole},
+		{"GET", "/admin/syncjob/runs/:param0", models.AdminRole},
+		{"PUT", "/admin/multipart/:param0", models.AdminRole},
+		{"POST", "/admin/multipart/part/:param0/:param1", models.AdminRole},
+		{"POST", "/admin/multipart/complete/:param0", models.AdminRole},
+		{"DELETE", "/admin/multipart/:param0", models.AdminRole},
+		{"GET", "/admin/users", models.AdminRole},
+		{"POST", "/admin/user/enable/:param0", models.AdminRole},
+		{"POST", "/admin/user/password/:param0", models.AdminRole},
+		{"POST", "/admin/user/role/:param0", models.SuperAdminRole},
+		{"DELETE", "/admin/user/:param0", models.AdminRole},
+	}
+
+	// 新增的接口也需要加入上面的列表
+	routes := map[string]bool{}
+	for _, test := range tests {
+		routes[test.method+" "+test.path] = true
+	}
+	for _, route := range e.Routes() {
+		if !routes[route.Method+" "+route.Path] {
+			t.Errorf("route %s %s is not tested", route.Method, route.Path)
+		}
+	}
+	if len(e.Routes()) != len(tests) {
+		t.Fatalf("unexpected routes %d", len(e.Routes()))
+	}
+
+	roles := []models.Role{"", models.UserRole, models.AdminRole, models.SuperAdminRole}
+	for _, test := range tests {
+		for _, role := range roles {
+			if role.Includes(test.role) {
+				break
+			}
+
+			req := httptest.NewRequest(test.method, strings.NewReplacer(":param0", "1", ":param1", "2").Replace(test.path), nil)
+			req.Header.Set("role", string(role))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("%s %s with role %q returns %d", test.method, test.path, role, rec.Code)
+			}
+		}
+	}
+}
@@ -55,8 +55,12 @@ type RegisterRouter interface {
 	RegisterRouter(routers ExtraRouter)
 }
 
+// MethodMiddleware 根据控制器以及方法名返回这个方法的路由需要额外添加的中间件，例如限制可以访问的角色
+type MethodMiddleware func(controller interface{}, funcName string) []echo.MiddlewareFunc
+
 type Application struct {
-	r *echo.Group
+	r           *echo.Group
+	middlewares []MethodMiddleware
 }
 
 func New(r *echo.Group) *Application {
@@ -65,6 +69,20 @@ func New(r *echo.Group) *Application {
 	}
 }
 
+// Use 添加注册路由时使用的MethodMiddleware，只对之后注册的控制器生效
+func (app *Application) Use(middlewares ...MethodMiddleware) *Application {
+	app.middlewares = append(app.middlewares, middlewares...)
+	return app
+}
+
+func (app *Application) methodMiddlewares(controller interface{}, funcName string) []echo.MiddlewareFunc {
+	m := []echo.MiddlewareFunc{}
+	for _, middleware := range app.middlewares {
+		m = append(m, middleware(controller, funcName)...)
+	}
+	return m
+}
+
 var echoContextType reflect.Type = reflect.TypeOf((*echo.Context)(nil)).Elem()
 var resultType reflect.Type = reflect.TypeOf((*Result)(nil)).Elem()
 var pathRegex = regexp.MustCompile(`([A-Z][a-z0-9]*)`)
@@ -212,17 +230,18 @@ func (app *Application) Handle(controller interface{}) {
 		if len(matches) > 0 {
 			if matches[0] == "Get" || matches[0] == "Post" || matches[0] == "Put" || matches[0] == "Delete" {
 				path := strings.ToLower(GetRouterPath(matches[1:], method))
+				m := app.methodMiddlewares(controller, methodName)
 				if matches[0] == "Get" {
-					app.r.GET(path, HandlerFunc(controller, method))
+					app.r.GET(path, HandlerFunc(controller, method), m...)
 				}
 				if matches[0] == "Post" {
-					app.r.POST(path, HandlerFunc(controller, method))
+					app.r.POST(path, HandlerFunc(controller, method), m...)
 				}
 				if matches[0] == "Put" {
-					app.r.PUT(path, HandlerFunc(controller, method))
+					app.r.PUT(path, HandlerFunc(controller, method), m...)
 				}
 				if matches[0] == "Delete" {
-					app.r.DELETE(path, HandlerFunc(controller, method))
+					app.r.DELETE(path, HandlerFunc(controller, method), m...)
 				}
 
 			}
@@ -244,17 +263,18 @@ func (app *Application) Handle(controller interface{}) {
 				}
 
 				if method, ok := st.MethodByName(item.funcName); ok {
+					m := app.methodMiddlewares(controller, item.funcName)
 					if item.method == "Get" {
-						app.r.GET(path, HandlerExtraRouterFunc(controller, method))
+						app.r.GET(path, HandlerExtraRouterFunc(controller, method), m...)
 					}
 					if item.method == "Post" {
-						app.r.POST(path, HandlerExtraRouterFunc(controller, method))
+						app.r.POST(path, HandlerExtraRouterFunc(controller, method), m...)
 					}
 					if item.method == "Put" {
-						app.r.PUT(path, HandlerExtraRouterFunc(controller, method))
+						app.r.PUT(path, HandlerExtraRouterFunc(controller, method), m...)
 					}
 					if item.method == "Delete" {
-						app.r.DELETE(path, HandlerExtraRouterFunc(controller, method))
+						app.r.DELETE(path, HandlerExtraRouterFunc(controller, method), m...)
 					}
 				}
 
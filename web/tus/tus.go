@@ -0,0 +1,428 @@
+package tus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/driver"
+	fileerr "github.com/lixiaofei123/nextlist/errors"
+	"github.com/lixiaofei123/nextlist/services"
+)
+
+const (
+	tusVersion    string = "1.0.0"
+	tusExtensions string = "creation,termination,expiration"
+
+	// uploadExpire 超过这个时间没有继续上传的数据会被清理
+	uploadExpire = 24 * time.Hour
+)
+
+// Server 实现了tus(https://tus.io)断点续传协议。数据先保存在本地的临时目录中，连接断开后可以从断开的位置继续上传，
+// 全部上传完成后再写入存储并确认文件，与PostConfirmFileBy的效果相同
+type Server struct {
+	dir     string
+	fileSrv services.FileService
+	driver  driver.Driver
+	lock    sync.Mutex
+	busy    map[string]bool
+}
+
+// upload 是一次上传的信息，已经上传的数据长度就是临时文件的大小
+type upload struct {
+	ID       string    `json:"id"`
+	FileId   string    `json:"fileId"`
+	UserName string    `json:"userName"`
+	Length   int64     `json:"length"`
+	ExpireAt time.Time `json:"expireAt"`
+}
+
+func New(dir string, fileSrv services.FileService, sdriver driver.Driver) (*Server, error) {
+
+	if dir == "" {
+		dir = path.Join(os.TempDir(), "nextlist", "tus")
+	}
+
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		dir:     dir,
+		fileSrv: fileSrv,
+		driver:  sdriver,
+		busy:    map[string]bool{},
+	}, nil
+}
+
+// Register 注册上传接口，g需要经过登录认证，m会添加到除了OPTIONS之外的所有接口上
+func (s *Server) Register(g *echo.Group, m ...echo.MiddlewareFunc) {
+	g.OPTIONS("/tus", s.handle(s.options))
+	g.POST("/tus", s.handle(s.create), m...)
+	g.HEAD("/tus/:id", s.handle(s.head), m...)
+	g.PATCH("/tus/:id", s.handle(s.patch), m...)
+	g.DELETE("/tus/:id", s.handle(s.terminate), m...)
+}
+
+func (s *Server) handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		header := ctx.Response().Header()
+		header.Set("Tus-Resumable", tusVersion)
+
+		if ctx.Request().Method != http.MethodOptions && ctx.Request().Header.Get("Tus-Resumable") != tusVersion {
+			header.Set("Tus-Version", tusVersion)
+			return ctx.String(http.StatusPreconditionFailed, "不支持的tus协议版本")
+		}
+
+		return next(ctx)
+	}
+}
+
+func (s *Server) options(ctx echo.Context) error {
+	header := ctx.Response().Header()
+	header.Set("Tus-Version", tusVersion)
+	header.Set("Tus-Extension", tusExtensions)
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) create(ctx echo.Context) error {
+
+	username := ctx.Request().Header.Get("username")
+
+	length, err := strconv.ParseInt(ctx.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return ctx.String(http.StatusBadRequest, "错误的Upload-Length")
+	}
+
+	// 需要先通过PreSaveFile创建文件，然后在元数据中带上文件的ID
+	metadata := parseMetadata(ctx.Request().Header.Get("Upload-Metadata"))
+	file, err := s.fileSrv.FindUploadingFile(username, metadata["fileId"])
+	if err != nil {
+		return writeError(ctx, err)
+	}
+
+	// 文件可能在很早之前就已经创建了，需要避免还没有开始上传就被清理
+	if err := s.fileSrv.TouchUploading(username, file.ID); err != nil {
+		return err
+	}
+
+	// 挂载了多个存储时，需要检查文件实际所在的存储是否支持直接写入
+	sdriver, _, err := driver.Resolve(s.driver, file.AbsolutePath)
+	if err != nil {
+		return writeError(ctx, err)
+	}
+	if _, ok := sdriver.(driver.Putter); !ok {
+		return ctx.String(http.StatusNotImplemented, fileerr.ErrUnSupportOperation.Error())
+	}
+
+	up := &upload{
+		ID:       uuid.NewString(),
+		FileId:   file.ID,
+		UserName: username,
+		Length:   length,
+		ExpireAt: time.Now().Add(uploadExpire),
+	}
+
+	if err := s.save(up); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(s.dataPath(up.ID), nil, 0640); err != nil {
+		return err
+	}
+
+	header := ctx.Response().Header()
+	header.Set("Location", strings.TrimRight(ctx.Request().URL.Path, "/")+"/"+up.ID)
+	header.Set("Upload-Expires", up.ExpireAt.UTC().Format(http.TimeFormat))
+
+	// 空文件不会再有PATCH请求，直接完成上传
+	if length == 0 {
+		if err := s.finish(up); err != nil {
+			return writeError(ctx, err)
+		}
+	}
+
+	return ctx.NoContent(http.StatusCreated)
+}
+
+func (s *Server) head(ctx echo.Context) error {
+
+	up, offset, err := s.load(ctx.Param("id"), ctx.Request().Header.Get("username"))
+	if err != nil {
+		return writeError(ctx, err)
+	}
+
+	header := ctx.Response().Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	header.Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	header.Set("Upload-Expires", up.ExpireAt.UTC().Format(http.TimeFormat))
+
+	return ctx.NoContent(http.StatusOK)
+}
+
+func (s *Server) patch(ctx echo.Context) error {
+
+	if ctx.Request().Header.Get("Content-Type") != "application/offset+octet-stream" {
+		return ctx.String(http.StatusUnsupportedMediaType, "错误的Content-Type")
+	}
+
+	id := ctx.Param("id")
+
+	// 同一个上传同时只能有一个请求在写入
+	s.lock.Lock()
+	if s.busy[id] {
+		s.lock.Unlock()
+		return ctx.String(http.StatusLocked, "正在上传中")
+	}
+	s.busy[id] = true
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.busy, id)
+		s.lock.Unlock()
+	}()
+
+	up, offset, err := s.load(id, ctx.Request().Header.Get("username"))
+	if err != nil {
+		return writeError(ctx, err)
+	}
+
+	requestOffset, err := strconv.ParseInt(ctx.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || requestOffset != offset {
+		return ctx.String(http.StatusConflict, "Upload-Offset与已经上传的长度不一致")
+	}
+
+	data, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	body := ctx.Request().Body
+	defer body.Close()
+
+	// 连接中途断开时，已经收到的数据仍然会被保存下来
+	n, copyErr := io.Copy(data, io.LimitReader(body, up.Length-offset))
+	if err := data.Close(); err != nil {
+		return err
+	}
+	offset += n
+
+	up.ExpireAt = time.Now().Add(uploadExpire)
+	if err := s.save(up); err != nil {
+		return err
+	}
+
+	if err := s.fileSrv.TouchUploading(up.UserName, up.FileId); err != nil {
+		return err
+	}
+
+	if copyErr != nil {
+		return copyErr
+	}
+
+	if offset == up.Length {
+		if err := s.finish(up); err != nil {
+			return writeError(ctx, err)
+		}
+	}
+
+	header := ctx.Response().Header()
+	header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	header.Set("Upload-Expires", up.ExpireAt.UTC().Format(http.TimeFormat))
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+func (s *Server) terminate(ctx echo.Context) error {
+
+	up, _, err := s.load(ctx.Param("id"), ctx.Request().Header.Get("username"))
+	if err != nil {
+		return writeError(ctx, err)
+	}
+
+	s.remove(up.ID)
+
+	return ctx.NoContent(http.StatusNoContent)
+}
+
+// finish 将上传完成的数据写入存储，然后确认文件
+func (s *Server) finish(up *upload) error {
+
+	file, err := s.fileSrv.FindUploadingFile(up.UserName, up.FileId)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.Open(s.dataPath(up.ID))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	putter, ok := s.driver.(driver.Putter)
+	if !ok {
+		return fileerr.ErrUnSupportOperation
+	}
+
+	if err := putter.Put(file.AbsolutePath, data, up.Length); err != nil {
+		return err
+	}
+
+	if _, err := s.fileSrv.FinishUpload(up.UserName, up.FileId, up.Length); err != nil {
+		return err
+	}
+
+	s.remove(up.ID)
+
+	return nil
+}
+
+// CleanExpired 清理超过有效期没有继续上传的数据
+func (s *Server) CleanExpired() {
+
+	infos, err := filepath.Glob(path.Join(s.dir, "*.info"))
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		id := strings.TrimSuffix(path.Base(info), ".info")
+
+		up, err := s.read(id)
+		if err != nil || up.ExpireAt.After(time.Now()) {
+			continue
+		}
+
+		s.lock.Lock()
+		busy := s.busy[id]
+		s.lock.Unlock()
+
+		if !busy {
+			s.remove(id)
+			log.Printf("已经清理过期的上传%s", id)
+		}
+	}
+}
+
+func (s *Server) infoPath(id string) string {
+	return path.Join(s.dir, id+".info")
+}
+
+func (s *Server) dataPath(id string) string {
+	return path.Join(s.dir, id)
+}
+
+func (s *Server) save(up *upload) error {
+
+	data, err := json.Marshal(up)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.infoPath(up.ID), data, 0640)
+}
+
+func (s *Server) read(id string) (*upload, error) {
+
+	// id会被用作文件名，不允许出现路径
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, fileerr.ErrFileNotFound
+	}
+
+	data, err := ioutil.ReadFile(s.infoPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fileerr.ErrFileNotFound
+		}
+		return nil, err
+	}
+
+	up := &upload{}
+	if err := json.Unmarshal(data, up); err != nil {
+		return nil, err
+	}
+
+	return up, nil
+}
+
+// load 返回上传的信息以及已经上传的数据长度，只能访问自己创建的上传
+func (s *Server) load(id, username string) (*upload, int64, error) {
+
+	up, err := s.read(id)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if up.UserName != username {
+		return nil, 0, fileerr.ErrFileNotFound
+	}
+
+	info, err := os.Stat(s.dataPath(id))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, 0, fileerr.ErrFileNotFound
+		}
+		return nil, 0, err
+	}
+
+	return up, info.Size(), nil
+}
+
+func (s *Server) remove(id string) {
+	os.Remove(s.dataPath(id))
+	os.Remove(s.infoPath(id))
+}
+
+// parseMetadata 解析Upload-Metadata，格式为逗号分隔的 "key base64(value)"
+func parseMetadata(header string) map[string]string {
+
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+
+		value := ""
+		if len(fields) > 1 {
+			if data, err := base64.StdEncoding.DecodeString(fields[1]); err == nil {
+				value = string(data)
+			}
+		}
+		metadata[fields[0]] = value
+	}
+
+	return metadata
+}
+
+func writeError(ctx echo.Context, err error) error {
+
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fileerr.ErrFileNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, fileerr.ErrNotEnoughPermission):
+		code = http.StatusForbidden
+	case errors.Is(err, fileerr.ErrFileUploaded), errors.Is(err, fileerr.ErrIsDirectory):
+		code = http.StatusBadRequest
+	case errors.Is(err, fileerr.ErrUnSupportOperation), errors.Is(err, fileerr.ErrNotMounted):
+		code = http.StatusNotImplemented
+	}
+
+	return ctx.String(code, err.Error())
+}
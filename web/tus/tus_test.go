@@ -0,0 +1,222 @@
+package tus
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lixiaofei123/nextlist/configs"
+	"github.com/lixiaofei123/nextlist/database"
+	"github.com/lixiaofei123/nextlist/driver"
+	models "github.com/lixiaofei123/nextlist/models"
+	"github.com/lixiaofei123/nextlist/services"
+)
+
+type testServer struct {
+	e       *echo.Echo
+	fileSrv services.FileService
+	root    string
+}
+
+func newTestServer(t *testing.T, mountS3 bool) *testServer {
+
+	db, err := database.Open(&configs.DataBase{
+		Type:   database.SQLITE,
+		Sqlite: configs.Sqlite{Path: filepath.Join(t.TempDir(), "nextlist.db")},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	fdriver, err := driver.GetDriver("file", map[string]interface{}{
+		"path": root,
+		"key":  "nextlist",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mounts := []*driver.Mount{{Path: "/", Driver: fdriver}}
+	if mountS3 {
+		// 对象存储不支持直接写入，创建驱动时不会访问网络
+		s3driver, err := driver.GetDriver("s3", map[string]interface{}{
+			"endpoint": "http://127.0.0.1:1",
+			"bucket":   "nextlist",
+			"region":   "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mounts = append(mounts, &driver.Mount{Path: "/s3", Driver: s3driver})
+	}
+
+	sdriver, err := driver.NewMountDriver(mounts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileSrv := services.NewFileService(db, sdriver)
+	server, err := New(t.TempDir(), fileSrv, sdriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	server.Register(e.Group("/api/v1/admin"))
+
+	return &testServer{e: e, fileSrv: fileSrv, root: root}
+}
+
+func (s *testServer) do(method, target string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("username", "alice")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+func (s *testServer) create(t *testing.T, parentId, name, length string) (*models.File, *httptest.ResponseRecorder) {
+
+	file, err := s.fileSrv.PreSaveFile("alice", &models.File{ParentId: parentId, Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return file, s.do(http.MethodPost, "/api/v1/admin/tus", nil, map[string]string{
+		"Upload-Length":   length,
+		"Upload-Metadata": "fileId " + base64.StdEncoding.EncodeToString([]byte(file.ID)),
+	})
+}
+
+func (s *testServer) patch(location string, offset string, body io.Reader) *httptest.ResponseRecorder {
+	return s.do(http.MethodPatch, location, body, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": offset,
+	})
+}
+
+// brokenReader 在读完数据之后返回错误，模拟连接中途断开
+type brokenReader struct {
+	data io.Reader
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func Test_UploadAndResume(t *testing.T) {
+
+	s := newTestServer(t, false)
+
+	file, rec := s.create(t, "", "a.txt", "6")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+
+	rec = s.do(http.MethodHead, location, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "0" || rec.Header().Get("Upload-Length") != "6" {
+		t.Fatalf("unexpected head %d %v", rec.Code, rec.Header())
+	}
+
+	rec = s.patch(location, "0", strings.NewReader("ab"))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "2" {
+		t.Fatalf("unexpected patch %d %v", rec.Code, rec.Header())
+	}
+
+	// 偏移量与已经上传的长度不一致
+	if rec = s.patch(location, "0", strings.NewReader("ab")); rec.Code != http.StatusConflict {
+		t.Fatalf("unexpected patch %d", rec.Code)
+	}
+
+	// 连接中途断开时已经收到的数据会被保留
+	s.patch(location, "2", &brokenReader{data: strings.NewReader("cd")})
+	rec = s.do(http.MethodHead, location, nil, nil)
+	if rec.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("unexpected offset %v", rec.Header())
+	}
+
+	rec = s.patch(location, "4", strings.NewReader("ef"))
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "6" {
+		t.Fatalf("unexpected patch %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 上传完成后文件已经写入存储并且被确认
+	data, err := ioutil.ReadFile(filepath.Join(s.root, "a.txt"))
+	if err != nil || string(data) != "abcdef" {
+		t.Fatalf("unexpected data %s %v", data, err)
+	}
+	if _, err := s.fileSrv.FindUploadingFile("alice", file.ID); err == nil {
+		t.Fatal("file should be confirmed")
+	}
+
+	if rec = s.do(http.MethodHead, location, nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unexpected head %d", rec.Code)
+	}
+}
+
+func Test_TerminateUpload(t *testing.T) {
+
+	s := newTestServer(t, false)
+
+	_, rec := s.create(t, "", "a.txt", "6")
+	location := rec.Header().Get("Location")
+
+	// 别人不能终止上传
+	req := httptest.NewRequest(http.MethodDelete, location, nil)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("username", "bob")
+	rec = httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unexpected delete %d", rec.Code)
+	}
+
+	if rec = s.do(http.MethodDelete, location, nil, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected delete %d", rec.Code)
+	}
+
+	if rec = s.do(http.MethodHead, location, nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unexpected head %d", rec.Code)
+	}
+}
+
+func Test_CreateUnsupportedStorage(t *testing.T) {
+
+	s := newTestServer(t, true)
+
+	dir, err := s.fileSrv.CreateDictory("alice", "", "s3", models.PUBLICREAD, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, rec := s.create(t, dir.ID, "b.txt", "6"); rec.Code != http.StatusNotImplemented {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 根目录挂载的是本地存储，可以正常上传
+	if _, rec := s.create(t, "", "a.txt", "6"); rec.Code != http.StatusCreated {
+		t.Fatalf("unexpected response %d %s", rec.Code, rec.Body.String())
+	}
+}